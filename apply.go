@@ -0,0 +1,95 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrInvalidOp is returned when an operation log contains an unknown kind of operation
+var ErrInvalidOp = errors.New("roaring: invalid operation")
+
+// OpKind represents the kind of a mutation in an operation log
+type OpKind byte
+
+const (
+	OpAdd         OpKind = iota // Adds the value Lo
+	OpRemove                    // Removes the value Lo
+	OpAddRange                  // Adds the values in the half-open interval [Lo, Hi)
+	OpRemoveRange               // Removes the values in the half-open interval [Lo, Hi)
+)
+
+// Op represents a single mutation in an operation log
+type Op struct {
+	Kind   OpKind // Kind of the mutation
+	Lo, Hi uint32 // Value or interval the mutation applies to, Hi is only used by ranges
+}
+
+// Apply applies the operations of the log in sequence. Consecutive operations of the
+// same kind are applied together through the batch paths, since they can be reordered
+// without changing the result. Empty or inverted ranges are ignored, and nothing is
+// applied if the log contains an unknown kind of operation.
+func (rb *Bitmap) Apply(ops []Op) error {
+	for i, op := range ops {
+		if op.Kind > OpRemoveRange {
+			return fmt.Errorf("%w: kind %d at %d", ErrInvalidOp, op.Kind, i)
+		}
+	}
+
+	var values []uint32
+	var runs [][2]uint32
+	for i := 0; i < len(ops); {
+		j := i + 1
+		for j < len(ops) && ops[j].Kind == ops[i].Kind {
+			j++
+		}
+
+		switch batch := ops[i:j]; {
+		case len(batch) == 1:
+			rb.apply(batch[0])
+		case batch[0].Kind == OpAdd || batch[0].Kind == OpRemove:
+			values = values[:0]
+			for _, op := range batch {
+				values = append(values, op.Lo)
+			}
+
+			slices.Sort(values)
+			if batch[0].Kind == OpAdd {
+				rb.OrValues(values)
+			} else {
+				rb.AndNotValues(values)
+			}
+		case batch[0].Kind == OpAddRange:
+			runs = runs[:0]
+			for _, op := range batch {
+				if op.Lo < op.Hi {
+					runs = append(runs, [2]uint32{op.Lo, op.Hi - 1})
+				}
+			}
+			rb.OrRuns(runs)
+		default:
+			for _, op := range batch {
+				rb.apply(op)
+			}
+		}
+		i = j
+	}
+	return nil
+}
+
+// apply applies a single operation of a known kind
+func (rb *Bitmap) apply(op Op) {
+	switch op.Kind {
+	case OpAdd:
+		rb.Set(op.Lo)
+	case OpRemove:
+		rb.Remove(op.Lo)
+	case OpAddRange:
+		rb.AddRange(op.Lo, op.Hi)
+	case OpRemoveRange:
+		rb.RemoveRange(op.Lo, op.Hi)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// genOps creates a log of mutations, where operations of the same kind tend to come
+// in bursts as they would when replaying the changes of a real workload, and ranges
+// are rarer than single values
+func genOps(size int, universe uint32) []Op {
+	ops := make([]Op, 0, size)
+	for len(ops) < size {
+		kind := OpKind(rand.IntN(2))
+		if rand.IntN(10) == 0 {
+			kind += OpAddRange
+		}
+
+		for n := 1 + rand.IntN(50); n > 0 && len(ops) < size; n-- {
+			lo := rand.Uint32N(universe)
+			hi := lo
+			if kind == OpAddRange || kind == OpRemoveRange {
+				hi = lo + min(rand.Uint32N(1000), math.MaxUint32-lo)
+				if rand.IntN(50) == 0 {
+					lo, hi = hi, lo // occasionally inverted, without wrapping around
+				}
+			}
+
+			ops = append(ops, Op{Kind: kind, Lo: lo, Hi: hi})
+		}
+	}
+	return ops
+}
+
+func TestApply(t *testing.T) {
+	for _, universe := range []uint32{1000, 1 << 20, 4294967295} {
+		ops := genOps(10000, universe)
+		rb, expect := makeTestBitmap(), makeTestBitmap()
+		assert.NoError(t, rb.Apply(ops))
+		for _, op := range ops {
+			expect.apply(op)
+		}
+
+		assert.NoError(t, rb.Validate())
+		bitmapsEqual(t, expect, rb)
+	}
+
+	t.Run("ordered", func(t *testing.T) {
+		rb := New()
+		assert.NoError(t, rb.Apply([]Op{
+			{Kind: OpAddRange, Lo: 0, Hi: 100},
+			{Kind: OpRemove, Lo: 10},
+			{Kind: OpRemove, Lo: 20},
+			{Kind: OpAdd, Lo: 10},
+			{Kind: OpRemoveRange, Lo: 50, Hi: 100},
+			{Kind: OpAdd, Lo: 70},
+			{Kind: OpAddRange, Lo: 5, Hi: 5},
+		}))
+
+		expect := append(seq(0, 20, 1), seq(21, 50, 1)...)
+		assert.Equal(t, append(expect, 70), rb.ToArray())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		rb := makeTestBitmap()
+		err := rb.Apply([]Op{{Kind: OpAdd, Lo: 3}, {Kind: 9}})
+		assert.ErrorIs(t, err, ErrInvalidOp)
+		bitmapsEqual(t, makeTestBitmap(), rb)
+		assert.NoError(t, rb.Apply(nil))
+	})
+}
+
+func BenchmarkApply(b *testing.B) {
+	ops := genOps(100000, 1<<24)
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			New().Apply(ops)
+		}
+	})
+
+	b.Run("single", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rb := New()
+			for _, op := range ops {
+				rb.apply(op)
+			}
+		}
+	})
+}
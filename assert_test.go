@@ -15,6 +15,11 @@ func bitmapWith(c *container) (*Bitmap, []uint16) {
 	return v, valuesOf(v)
 }
 
+func bitmapOf(c *container) *Bitmap {
+	v, _ := bitmapWith(c)
+	return v
+}
+
 func valuesOf(v *Bitmap) []uint16 {
 	out := []uint16{}
 	v.Range(func(x uint32) bool {
@@ -36,6 +41,14 @@ func newBmp(data ...uint32) *container {
 	return newContainer(typeBitmap, data...)
 }
 
+// seq creates the values in [from, to) with the given step
+func seq(from, to, step int) (out []uint32) {
+	for v := from; v < to; v += step {
+		out = append(out, uint32(v))
+	}
+	return
+}
+
 // newBmpPermutations creates a Bitmap with all 16 4-bit permutations
 func newBmpPermutations() *container {
 	rb := newBmp()
@@ -109,6 +109,35 @@ func runMath(b *bench.B) {
 			}
 		}
 	}
+
+	// Cardinalities only, without materializing the result
+	cardinalities := []struct {
+		name  string
+		ourFn func(*rb.Bitmap, *rb.Bitmap) int
+		refFn func(*roaring.Bitmap, *roaring.Bitmap) uint64
+	}{
+		{"and#", (*rb.Bitmap).AndCardinality, (*roaring.Bitmap).AndCardinality},
+		{"or#", (*rb.Bitmap).OrCardinality, (*roaring.Bitmap).OrCardinality},
+	}
+
+	for _, op := range cardinalities {
+		for _, size := range sizes {
+			for _, shape := range shapes {
+				data := shape.gen(size)
+				our, ref := randomBitmaps(data)
+				ourSrc, refSrc := randomBitmaps(data)
+				our.Optimize()
+				ref.RunOptimize()
+				ourSrc.Optimize()
+				refSrc.RunOptimize()
+
+				name := fmt.Sprintf("%s %s (%s) ", op.name, formatSize(size), shape.name)
+				b.Run(name,
+					func(_ int) { op.ourFn(our, ourSrc) },
+					func(_ int) { op.refFn(ref, refSrc) })
+			}
+		}
+	}
 }
 
 func runRange(b *bench.B) {
@@ -53,3 +53,11 @@ func asBitmap(data []uint16) bitmap.Bitmap {
 func asUint16s(data bitmap.Bitmap) []uint16 {
 	return unsafe.Slice((*uint16)(unsafe.Pointer(&data[0])), len(data)*4)
 }
+
+func asRuns(data []uint16) [][2]uint16 {
+	if len(data) < 2 {
+		return nil
+	}
+
+	return unsafe.Slice((*[2]uint16)(unsafe.Pointer(&data[0])), len(data)/2)
+}
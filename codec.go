@@ -6,79 +6,86 @@ package roaring
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
-	"math/bits"
 	"unsafe"
 )
 
 var isLittleEndian = binary.LittleEndian.Uint16([]byte{1, 0}) == 1
 
+// ErrInvalidContainerType is returned when a container has an unknown type
+var ErrInvalidContainerType = errors.New("roaring: invalid container type")
+
 // ToBytes converts the bitmap to a byte slice
 func (rb *Bitmap) ToBytes() []byte {
+	out, err := rb.ToBytesSafe()
+	if err != nil {
+		panic(err)
+	}
+
+	return out
+}
+
+// ToBytesSafe converts the bitmap to a byte slice, returning an error on failure
+func (rb *Bitmap) ToBytesSafe() ([]byte, error) {
 	var buf bytes.Buffer
 	if _, err := rb.WriteTo(&buf); err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 // WriteTo writes the bitmap to a writer
 func (rb *Bitmap) WriteTo(w io.Writer) (int64, error) {
 	var n int64
+	var header [7]byte
 
 	// Write number of containers
-	count := uint32(len(rb.containers))
-	if err := binary.Write(w, binary.LittleEndian, count); err != nil {
+	binary.LittleEndian.PutUint32(header[:4], uint32(len(rb.containers)))
+	if _, err := w.Write(header[:4]); err != nil {
 		return n, err
 	}
 	n += 4
 
-	for i, c := range rb.containers {
-		key := rb.index[i]
-
-		// Write key (uint16)
-		if err := binary.Write(w, binary.LittleEndian, key); err != nil {
+	for i := range rb.containers {
+		m, err := writeContainer(w, &header, rb.index[i], &rb.containers[i])
+		if n += m; err != nil {
 			return n, err
 		}
-		n += 2
+	}
+	return n, nil
+}
 
-		// Write type (byte)
-		if err := binary.Write(w, binary.LittleEndian, c.Type); err != nil {
-			return n, err
-		}
-		n += 1
-
-		// Prepare payload
-		var payload []uint16
-		var sizeBytes uint32
-		switch c.Type {
-		case typeArray:
-			payload = c.Data[:len(c.Data)]
-			sizeBytes = uint32(len(payload)) * 2
-		case typeBitmap:
-			payload = c.Data[:4096] // Bitmap containers always have a fixed size of 4096 uint16s
-			sizeBytes = uint32(len(payload)) * 2
-		case typeRun:
-			payload = c.Data[:len(c.Data)]
-			sizeBytes = uint32(len(payload)) * 2
-		default:
-			return n, io.ErrUnexpectedEOF
-		}
+// writeContainer writes a single container with its header to a writer
+func writeContainer(w io.Writer, header *[7]byte, key uint16, c *container) (int64, error) {
+	var payload []uint16
+	switch c.Type {
+	case typeArray:
+		payload = c.Data[:len(c.Data)]
+	case typeBitmap:
+		payload = c.Data[:4096] // Bitmap containers always have a fixed size of 4096 uint16s
+	case typeRun:
+		payload = c.Data[:len(c.Data)]
+	default:
+		return 0, fmt.Errorf("%w: type %d at key %d", ErrInvalidContainerType, c.Type, key)
+	}
 
-		// Write size (uint32)
-		if err := binary.Write(w, binary.LittleEndian, sizeBytes); err != nil {
-			return n, err
-		}
-		n += 4
+	// Write key (uint16), type (byte) and size (uint32) at once
+	sizeBytes := uint32(len(payload)) * 2
+	binary.LittleEndian.PutUint16(header[0:2], key)
+	header[2] = byte(c.Type)
+	binary.LittleEndian.PutUint32(header[3:7], sizeBytes)
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
 
-		// Write payload ([]uint16)
-		if err := writeUint16s(w, isLittleEndian, payload); err != nil {
-			return n, err
-		}
-		n += int64(sizeBytes)
+	// Write payload ([]uint16)
+	if err := writeUint16s(w, isLittleEndian, payload); err != nil {
+		return int64(len(header)), err
 	}
-	return n, nil
+	return int64(len(header)) + int64(sizeBytes), nil
 }
 
 // ReadFrom reads the bitmap from a reader
@@ -94,76 +101,87 @@ func (rb *Bitmap) ReadFrom(r io.Reader) (int64, error) {
 	n += 4
 
 	for i := uint32(0); i < count; i++ {
-		var key uint16
-		if err := binary.Read(r, binary.LittleEndian, &key); err != nil {
+		key, typ, m, err := readHeader(r)
+		if n += m; err != nil {
 			return n, err
 		}
-		n += 2
 
-		var typ ctype
-		if err := binary.Read(r, binary.LittleEndian, &typ); err != nil {
-			return n, err
+		if m, err = rb.readContainer(r, key, typ); err != nil {
+			if errors.Is(err, ErrInvalidContainerType) {
+				err = fmt.Errorf("%w (container %d)", err, i)
+			}
+			return n + m, err
 		}
-		n += 1
+		n += m
+	}
+	return n, nil
+}
 
-		var sizeBytes uint32
-		if err := binary.Read(r, binary.LittleEndian, &sizeBytes); err != nil {
-			return n, err
+// readHeader reads the key (uint16) and type (byte) of a container from a reader
+func readHeader(r io.Reader) (key uint16, typ ctype, n int64, err error) {
+	if err = binary.Read(r, binary.LittleEndian, &key); err != nil {
+		return
+	}
+	n += 2
+
+	if err = binary.Read(r, binary.LittleEndian, &typ); err != nil {
+		return
+	}
+	n += 1
+	return
+}
+
+// readContainer reads the size and payload of a container from a reader and appends
+// the container to the bitmap.
+func (rb *Bitmap) readContainer(r io.Reader, key uint16, typ ctype) (int64, error) {
+	var n int64
+	var sizeBytes uint32
+	if err := binary.Read(r, binary.LittleEndian, &sizeBytes); err != nil {
+		return n, err
+	}
+	n += 4
+
+	payload, err := readUint16s(r, isLittleEndian, int(sizeBytes))
+	if err != nil {
+		return n, err
+	}
+	n += int64(sizeBytes)
+
+	switch typ {
+	case typeArray, typeBitmap, typeRun:
+		if i := len(rb.index); i > 0 && rb.index[i-1] >= key {
+			return n, fmt.Errorf("%w: key %d is out of order", ErrInvalidContainer, key)
 		}
-		n += 4
 
-		payload, err := readUint16s(r, isLittleEndian, int(sizeBytes))
-		if err != nil {
-			return n, err
+		if typ == typeBitmap && len(payload) != bitmapSize {
+			return n, fmt.Errorf("%w: bitmap at key %d has %d words, expected %d", ErrInvalidContainer, key, len(payload), bitmapSize)
 		}
-		n += int64(sizeBytes)
-
-		switch typ {
-		case typeArray:
-			rb.ctrAdd(key, len(rb.containers), &container{
-				Type: typ,
-				Size: uint32(len(payload)),
-				Data: payload,
-			})
-		case typeBitmap:
-			// Count bits set for Size
-			sz := uint32(0)
-			for _, v := range payload {
-				sz += uint32(bits.OnesCount16(v))
-			}
-			rb.ctrAdd(key, len(rb.containers), &container{
-				Type: typ,
-				Size: sz,
-				Data: payload,
-			})
-		case typeRun:
-			// Calculate run cardinality
-			sz := uint32(0)
-			for i := 0; i+1 < len(payload); i += 2 {
-				sz += uint32(payload[i+1]-payload[i]) + 1
-			}
-			rb.ctrAdd(key, len(rb.containers), &container{
-				Type: typ,
-				Size: sz,
-				Data: payload,
-			})
-		default:
-			return n, io.ErrUnexpectedEOF
+
+		c := container{Type: typ, Data: payload}
+		if c.Size = c.cardinality(); c.Size > 0 {
+			rb.ctrAdd(key, len(rb.containers), &c) // Empty containers are skipped
 		}
+	default:
+		return n, fmt.Errorf("%w: type %d at key %d", ErrInvalidContainerType, typ, key)
 	}
 	return n, nil
 }
 
 // FromBytes creates a roaring bitmap from a byte buffer
 func FromBytes(buffer []byte) *Bitmap {
-	rb := New()
-	_, err := rb.ReadFrom(bytes.NewReader(buffer))
-	if err != nil && err != io.EOF {
+	rb, err := FromBytesSafe(buffer)
+	if err != nil {
 		panic(err)
 	}
 	return rb
 }
 
+// FromBytesSafe creates a roaring bitmap from a byte buffer, returning an error
+// if the buffer is malformed instead of panicking.
+func FromBytesSafe(buffer []byte) (*Bitmap, error) {
+	return ReadFrom(bytes.NewReader(buffer))
+}
+
 // ReadFrom reads a roaring bitmap from an io.Reader
 func ReadFrom(r io.Reader) (*Bitmap, error) {
 	rb := New()
@@ -177,8 +195,10 @@ func ReadFrom(r io.Reader) (*Bitmap, error) {
 // writeUint16s writes a slice of uint16s to a writer, converting it to []byte if
 // the machine is little endian.
 func writeUint16s(w io.Writer, isLittleEndian bool, data []uint16) error {
-	switch isLittleEndian {
-	case true:
+	switch {
+	case len(data) == 0:
+		return nil
+	case isLittleEndian:
 		buf := unsafe.Slice((*byte)(unsafe.Pointer(&data[0])), len(data)*2)
 		_, err := w.Write(buf)
 		return err
@@ -191,10 +211,12 @@ func writeUint16s(w io.Writer, isLittleEndian bool, data []uint16) error {
 // the machine is little endian.
 func readUint16s(r io.Reader, isLittleEndian bool, sizeBytes int) ([]uint16, error) {
 	count := sizeBytes / 2
-	switch isLittleEndian {
-	case true:
+	switch {
+	case count == 0:
+		return []uint16{}, nil
+	case isLittleEndian:
 		out := make([]byte, sizeBytes)
-		_, err := r.Read(out)
+		_, err := io.ReadFull(r, out)
 		return unsafe.Slice((*uint16)(unsafe.Pointer(&out[0])), count), err
 	default:
 		out := make([]uint16, count)
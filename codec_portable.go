@@ -0,0 +1,266 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+const (
+	portableCookie      = 12347 // Cookie of the portable format when there are run containers
+	portableCookieNoRun = 12346 // Cookie of the portable format without any run container
+	portableNoOffset    = 4     // Containers below which the offset header is omitted with runs
+	portableMaxArray    = 4096  // Cardinality up to which containers are stored as arrays
+)
+
+// ErrInvalidCookie is returned when a buffer does not start with a portable format cookie
+var ErrInvalidCookie = errors.New("roaring: invalid portable format cookie")
+
+// WriteToPortable writes the bitmap to a writer in the portable format shared by the
+// Java, C/C++ and Go implementations of roaring bitmaps, so that they can read it. The
+// format picks arrays and bitmaps by cardinality alone, so containers holding up to 4096
+// values are written as arrays and the others as bitmaps, unless they are runs.
+func (rb *Bitmap) WriteToPortable(w io.Writer) (int64, error) {
+	count, hasRun := 0, false
+	for i := range rb.containers {
+		if c := &rb.containers[i]; c.Size > 0 { // Empty containers are skipped
+			count++
+			hasRun = hasRun || c.Type == typeRun
+		}
+	}
+
+	// Cookie, followed by the flags of the run containers or the number of containers
+	var header []byte
+	switch {
+	case hasRun:
+		header = binary.LittleEndian.AppendUint32(header, portableCookie|uint32(count-1)<<16)
+		header = append(header, make([]byte, (count+7)/8)...)
+	default:
+		header = binary.LittleEndian.AppendUint32(header, portableCookieNoRun)
+		header = binary.LittleEndian.AppendUint32(header, uint32(count))
+	}
+
+	// Descriptive header with the key and cardinality of every container
+	j := 0
+	for i := range rb.containers {
+		c := &rb.containers[i]
+		if c.Size == 0 {
+			continue
+		}
+
+		if c.Type == typeRun {
+			header[4+j/8] |= 1 << (j % 8)
+		}
+
+		header = binary.LittleEndian.AppendUint16(header, rb.index[i])
+		header = binary.LittleEndian.AppendUint16(header, uint16(c.Size-1))
+		j++
+	}
+
+	// Offset header with the position of every container, which readers use to seek
+	if !hasRun || count >= portableNoOffset {
+		offset := uint32(len(header) + 4*count)
+		for i := range rb.containers {
+			if c := &rb.containers[i]; c.Size > 0 {
+				header = binary.LittleEndian.AppendUint32(header, offset)
+				offset += uint32(portableSize(c))
+			}
+		}
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+
+	n := int64(len(header))
+	var runs []uint16
+	for i := range rb.containers {
+		c := &rb.containers[i]
+		if c.Size == 0 {
+			continue
+		}
+
+		var err error
+		switch tmp := *c; {
+		case c.Type == typeRun:
+			runs = append(runs[:0], uint16(len(c.Data)/2))
+			for k := 0; k+1 < len(c.Data); k += 2 {
+				runs = append(runs, c.Data[k], c.Data[k+1]-c.Data[k])
+			}
+			err = writeUint16s(w, isLittleEndian, runs)
+		case c.Size <= portableMaxArray:
+			if tmp.Type == typeBitmap {
+				tmp.bmpToArr()
+			}
+			err = writeUint16s(w, isLittleEndian, tmp.Data)
+		default:
+			if tmp.Type == typeArray {
+				tmp.arrToBmp()
+			}
+			err = writeWords(w, tmp.bmp())
+		}
+
+		if err != nil {
+			return n, err
+		}
+		n += int64(portableSize(c))
+	}
+	return n, nil
+}
+
+// portableSize returns the size in bytes of the body of a container in the portable format
+func portableSize(c *container) int {
+	switch {
+	case c.Type == typeRun:
+		return 2 + 2*len(c.Data)
+	case c.Size <= portableMaxArray:
+		return 2 * int(c.Size)
+	default:
+		return 2 * bitmapSize
+	}
+}
+
+// ReadFromPortable reads a bitmap written in the portable format shared by the Java,
+// C/C++ and Go implementations of roaring bitmaps, replacing the contents of this one.
+func (rb *Bitmap) ReadFromPortable(r io.Reader) (int64, error) {
+	rb.Clear()
+	var n int64
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return n, err
+	}
+	n += 4
+
+	// Cookie, followed by the flags of the run containers or the number of containers
+	var count int
+	var runFlags []byte
+	switch cookie := binary.LittleEndian.Uint32(buf[:4]); {
+	case cookie&0xFFFF == portableCookie:
+		count = int(cookie>>16) + 1
+		runFlags = make([]byte, (count+7)/8)
+		if _, err := io.ReadFull(r, runFlags); err != nil {
+			return n, err
+		}
+		n += int64(len(runFlags))
+	case cookie == portableCookieNoRun:
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return n, err
+		}
+		n += 4
+
+		if count = int(binary.LittleEndian.Uint32(buf[:4])); count > 1<<16 {
+			return n, fmt.Errorf("%w: %d containers", ErrInvalidContainer, count)
+		}
+	default:
+		return n, fmt.Errorf("%w: %d", ErrInvalidCookie, cookie)
+	}
+
+	// Descriptive header, then the offset header which is not needed to read sequentially
+	header := make([]byte, 4*count)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return n, err
+	}
+	n += int64(len(header))
+
+	if runFlags == nil || count >= portableNoOffset {
+		m, err := io.CopyN(io.Discard, r, int64(4*count))
+		if n += m; err != nil {
+			return n, err
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		key := binary.LittleEndian.Uint16(header[4*i:])
+		size := uint32(binary.LittleEndian.Uint16(header[4*i+2:])) + 1
+		isRun := runFlags != nil && runFlags[i/8]&(1<<(i%8)) != 0
+		m, err := rb.readPortable(r, key, size, isRun)
+		if n += m; err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readPortable reads the body of a container in the portable format from a reader and
+// appends the container to the bitmap.
+func (rb *Bitmap) readPortable(r io.Reader, key uint16, size uint32, isRun bool) (int64, error) {
+	if i := len(rb.index); i > 0 && rb.index[i-1] >= key {
+		return 0, fmt.Errorf("%w: key %d is out of order", ErrInvalidContainer, key)
+	}
+
+	var n int64
+	c := container{Size: size}
+	switch {
+	case isRun:
+		var buf [2]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return n, err
+		}
+		n += 2
+
+		runs := int(binary.LittleEndian.Uint16(buf[:]))
+		data, err := readUint16s(r, isLittleEndian, 4*runs)
+		if err != nil {
+			return n, err
+		}
+		n += int64(4 * runs)
+
+		// Runs are stored as a start and a length minus one, rather than an end
+		for k := 0; k+1 < len(data); k += 2 {
+			end := uint32(data[k]) + uint32(data[k+1])
+			if end > 0xFFFF {
+				return n, fmt.Errorf("%w: run at key %d overflows", ErrInvalidContainer, key)
+			}
+			data[k+1] = uint16(end)
+		}
+		c.Type, c.Data = typeRun, data
+	case size <= portableMaxArray:
+		data, err := readUint16s(r, isLittleEndian, 2*int(size))
+		if err != nil {
+			return n, err
+		}
+		n += int64(2 * size)
+		c.Type, c.Data = typeArray, data
+	default:
+		data, err := readWords(r)
+		if err != nil {
+			return n, err
+		}
+		n += 2 * bitmapSize
+		c.Type, c.Data = typeBitmap, data
+	}
+
+	if actual := c.cardinality(); actual != size {
+		return n, fmt.Errorf("%w: key %d has %d values, expected %d", ErrInvalidContainer, key, actual, size)
+	}
+
+	rb.ctrAdd(key, len(rb.containers), &c)
+	return n, nil
+}
+
+// writeWords writes the words of a bitmap container to a writer in little endian
+func writeWords(w io.Writer, words []uint64) error {
+	if isLittleEndian {
+		return writeUint16s(w, true, unsafe.Slice((*uint16)(unsafe.Pointer(&words[0])), len(words)*4))
+	}
+	return binary.Write(w, binary.LittleEndian, words)
+}
+
+// readWords reads the words of a bitmap container in little endian from a reader, and
+// returns them as the data of a bitmap container
+func readWords(r io.Reader) ([]uint16, error) {
+	if isLittleEndian {
+		return readUint16s(r, true, 2*bitmapSize)
+	}
+
+	words := make([]uint64, bitmapSize/4)
+	if err := binary.Read(r, binary.LittleEndian, words); err != nil {
+		return nil, err
+	}
+	return unsafe.Slice((*uint16)(unsafe.Pointer(&words[0])), bitmapSize), nil
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// portableFixtures returns the values of the golden files in testdata/portable, which
+// were written by the Go implementation of roaring bitmaps (v1.9.4), as is and after a
+// RunOptimize, into <name>.bin and <name>.runs.bin respectively.
+func portableFixtures() map[string][]uint32 {
+	return map[string][]uint32{
+		"empty":     {},
+		"single":    {1},
+		"max":       {4294967295},
+		"pair":      {1, 1<<16 | 1},
+		"array":     append(seq(0, 3000, 7), seq(5<<16, 5<<16+100, 1)...),
+		"bitmap":    append(seq(0, 1<<16, 2), seq(1<<16, 2<<16, 3)...),
+		"full":      seq(0, 1<<16, 1),
+		"few-runs":  seq(10, 70000, 1), // No offset header
+		"many-runs": seq(0, 5<<16, 1),
+		"mixed": slices.Concat(seq(0, 100, 1), seq(1000, 2000, 2), seq(3<<16, 4<<16, 2),
+			seq(7<<16|100, 7<<16|50000, 1), []uint32{9<<16 | 5}),
+	}
+}
+
+// readFixture reads the golden file with the given name from testdata/portable
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", "portable", name))
+	assert.NoError(t, err)
+	return data
+}
+
+func TestCodec_Portable(t *testing.T) {
+	for name, values := range portableFixtures() {
+		t.Run(name, func(t *testing.T) {
+			expect := FromArray(values).ToArray()
+			for _, file := range []string{name + ".bin", name + ".runs.bin"} {
+				golden := readFixture(t, file)
+
+				// Decoded by us
+				out := New()
+				n, err := out.ReadFromPortable(bytes.NewReader(golden))
+				assert.NoError(t, err, file)
+				assert.Equal(t, int64(len(golden)), n, file)
+				assert.NoError(t, out.Validate())
+				assert.Equal(t, expect, out.ToArray(), file)
+
+				// Encoded back to the very same bytes
+				var buf bytes.Buffer
+				n, err = out.WriteToPortable(&buf)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(buf.Len()), n)
+				assert.Equal(t, golden, buf.Bytes(), file)
+			}
+
+			// Without runs, arrays and bitmaps are written by cardinality whatever their type
+			if golden := readFixture(t, name+".bin"); binary.LittleEndian.Uint32(golden) == portableCookieNoRun {
+				for _, typ := range []ContainerType{ContainerArray, ContainerBitmap} {
+					var buf bytes.Buffer
+					_, err := NewForTest(typ, values...).WriteToPortable(&buf)
+					assert.NoError(t, err)
+					assert.Equal(t, golden, buf.Bytes(), typ.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCodec_PortableShapes(t *testing.T) {
+	large := FromArray(seq(0, 20000, 3))
+	large.PinType(0, ContainerArray) // Array above 4096 values, written as a bitmap
+
+	small := FromArray([]uint32{1, 2, 3})
+	small.PinType(0, ContainerBitmap) // Bitmap below 4096 values, written as an array
+
+	for name, rb := range map[string]*Bitmap{
+		"empty":      New(),
+		"few runs":   FromRange(10, 70000), // No offset header
+		"many runs":  FromRange(0, 5<<16),
+		"full":       FromRange(0, 1<<16),
+		"max":        Of(4294967295),
+		"large arr":  large,
+		"small bmp":  small,
+		"test":       makeTestBitmap(),
+		"mixed runs": func() *Bitmap { rb := makeTestBitmap(); rb.AddRange(3<<16, 5<<16); return rb }(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := rb.WriteToPortable(&buf)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(buf.Len()), n)
+
+			out := New()
+			m, err := out.ReadFromPortable(&buf)
+			assert.NoError(t, err)
+			assert.Equal(t, n, m)
+			assert.NoError(t, out.Validate())
+			assert.Equal(t, rb.ToArray(), out.ToArray())
+		})
+	}
+
+	// Pinned containers are written as the format expects, like unpinned ones
+	for _, rb := range []*Bitmap{large, small} {
+		var pinned, plain bytes.Buffer
+		_, err := rb.WriteToPortable(&pinned)
+		assert.NoError(t, err)
+		_, err = NewForTest(ContainerArray, rb.ToArray()...).WriteToPortable(&plain)
+		assert.NoError(t, err)
+		assert.Equal(t, plain.Bytes(), pinned.Bytes())
+	}
+}
+
+func TestCodec_PortableInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := makeTestBitmap().WriteToPortable(&buf)
+	assert.NoError(t, err)
+	valid := buf.Bytes()
+
+	// Unknown cookie
+	_, err = New().ReadFromPortable(bytes.NewReader([]byte{1, 2, 3, 4}))
+	assert.ErrorIs(t, err, ErrInvalidCookie)
+
+	// Truncated at various positions
+	for i := 0; i < len(valid); i += 97 {
+		_, err := New().ReadFromPortable(bytes.NewReader(valid[:i]))
+		assert.Error(t, err, "truncated at %d", i)
+	}
+
+	// Keys out of order
+	pair := readFixture(t, "pair.bin")
+	swapped := bytes.Clone(pair)
+	binary.LittleEndian.PutUint16(swapped[8:], 1)
+	binary.LittleEndian.PutUint16(swapped[12:], 0)
+	_, err = New().ReadFromPortable(bytes.NewReader(swapped))
+	assert.ErrorIs(t, err, ErrInvalidContainer)
+
+	// Cardinality which does not match the contents
+	broken := bytes.Clone(pair)
+	binary.LittleEndian.PutUint16(broken[10:], 5)
+	_, err = New().ReadFromPortable(bytes.NewReader(broken))
+	assert.Error(t, err)
+}
@@ -5,8 +5,10 @@ package roaring
 
 import (
 	"bytes"
+	"encoding/binary"
 	"math/rand"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -75,6 +77,44 @@ func TestCodec_Package_ReadFrom(t *testing.T) {
 	bitmapsEqual(t, rb, rb2)
 }
 
+func TestCodec_ClearReadFrom(t *testing.T) {
+	src := makeTestBitmap()
+	src.AddRange(1<<20, 1<<20+100)
+	data := src.ToBytes()
+
+	rb := New()
+	for i := uint32(0); i < 100000; i += 7 {
+		rb.Set(i)
+	}
+
+	rb.Clear()
+	assert.Equal(t, 0, rb.Count())
+	_, ok := rb.Min()
+	assert.False(t, ok)
+
+	// Loading into a cleared or a populated bitmap replaces its contents entirely
+	for _, dst := range []*Bitmap{rb, makeTestBitmap()} {
+		dst.Set(3 << 16)
+		_, err := dst.ReadFrom(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, src.Count(), dst.Count())
+		bitmapsEqual(t, src, dst)
+
+		dst.Clear()
+		assert.Equal(t, 0, dst.Count())
+	}
+}
+
+func TestCodec_EmptyContainer(t *testing.T) {
+	rb := makeTestBitmap()
+	rb.ctrAdd(5, 3, &container{Type: typeArray, Data: []uint16{}})
+
+	out := FromBytes(rb.ToBytes())
+	assert.NoError(t, out.Validate())
+	assert.Equal(t, []uint16{0, 1, 2, 65535}, out.index)
+	assert.True(t, FromBytes(FromArray(nil).ToBytes()).IsEmpty())
+}
+
 func TestCodec_EmptyBitmap(t *testing.T) {
 	rb := New()
 	data := rb.ToBytes()
@@ -100,6 +140,43 @@ func TestCodec_DenseBitmap(t *testing.T) {
 	bitmapsEqual(t, rb, rb2)
 }
 
+func TestCodec_FullRun(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		runs []uint16
+	}{
+		{"single", []uint16{0, 65535}},
+		{"split", []uint16{0, 32767, 32768, 65535}},
+		{"last", []uint16{65535, 65535}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rb := New()
+			rb.ctrAdd(0, 0, &container{Type: typeRun, Data: tc.runs})
+			rb.ctrAdd(7, 1, &container{Type: typeRun, Data: tc.runs})
+			rb.containers[0].Size = rb.containers[0].cardinality()
+			rb.containers[1].Size = rb.containers[1].cardinality()
+
+			out, err := FromBytesSafe(rb.ToBytes())
+			assert.NoError(t, err)
+			assert.NoError(t, out.Validate())
+			assert.Equal(t, 2*rb.containers[0].cardinality(), uint32(out.Count()))
+			for i := range out.containers {
+				assert.Equal(t, typeRun, out.containers[i].Type)
+				assert.Equal(t, rb.containers[i].Size, out.containers[i].Size)
+			}
+		})
+	}
+
+	// The whole universe is made of full containers
+	rb := New()
+	rb.AddRangeClosed(0, 4294967295)
+	out, err := FromBytesSafe(rb.ToBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(65536), out.containers[0].Size)
+	assert.Equal(t, 1<<32, out.Count())
+	assert.True(t, out.Contains(4294967295))
+}
+
 func TestCodec_SparseRandom(t *testing.T) {
 	rb := New()
 	for i := 0; i < 1000; i++ {
@@ -129,3 +206,106 @@ func TestCodec_BigEndian(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, data, out2)
 }
+
+func TestCodec_InvalidContainerType(t *testing.T) {
+	data := makeTestBitmap().ToBytes()
+	data[6] = 0xFF // type byte of the first container (count + key)
+
+	_, err := FromBytesSafe(data)
+	assert.ErrorIs(t, err, ErrInvalidContainerType)
+	assert.Contains(t, err.Error(), "type 255 at key 0")
+	assert.Panics(t, func() { FromBytes(data) })
+
+	rb := makeTestBitmap()
+	rb.containers[1].Type = 0xFF
+	_, err = rb.ToBytesSafe()
+	assert.ErrorIs(t, err, ErrInvalidContainerType)
+	assert.Panics(t, func() { rb.ToBytes() })
+}
+
+func TestCodec_DuplicateKey(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		key  byte
+	}{
+		{"duplicate", 3},
+		{"out of order", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rb := FromArray([]uint32{3 << 16, 5 << 16})
+			data := rb.ToBytes()
+
+			// Overwrite the key of the second container, after the count and the first one
+			second := 4 + 7 + 2*len(rb.containers[0].Data)
+			data[second], data[second+1] = tc.key, 0
+
+			_, err := FromBytesSafe(data)
+			assert.ErrorIs(t, err, ErrInvalidContainer)
+			assert.Contains(t, err.Error(), "is out of order")
+			assert.Panics(t, func() { FromBytes(data) })
+		})
+	}
+}
+
+func TestCodec_ShortBitmap(t *testing.T) {
+	data := bitmapOf(newBmp(1, 65504)).ToBytes()
+
+	// Shrink the payload of the container to 4095 words, after the count, key and type
+	binary.LittleEndian.PutUint32(data[7:], 2*4095)
+	data = data[:len(data)-2]
+
+	_, err := FromBytesSafe(data)
+	assert.ErrorIs(t, err, ErrInvalidContainer)
+	assert.Contains(t, err.Error(), "has 4095 words")
+}
+
+func TestCodec_Endianness(t *testing.T) {
+	defer func(v bool) { isLittleEndian = v }(isLittleEndian)
+
+	for _, little := range []bool{true, false} {
+		isLittleEndian = little
+		rb := makeTestBitmap()
+		data := rb.ToBytes()
+
+		out, err := FromBytesSafe(data)
+		assert.NoError(t, err)
+		assert.NoError(t, out.Validate())
+		bitmapsEqual(t, rb, out)
+
+		// Readers may return fewer bytes than requested
+		out, err = ReadFrom(iotest.OneByteReader(bytes.NewReader(data)))
+		assert.NoError(t, err)
+		bitmapsEqual(t, rb, out)
+
+		// The encoding must not depend on the branch taken
+		isLittleEndian = !little
+		assert.Equal(t, data, rb.ToBytes())
+	}
+}
+
+// countingWriter counts the number of writes and bytes written
+type countingWriter struct {
+	writes, bytes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	w.bytes += len(p)
+	return len(p), nil
+}
+
+func BenchmarkCodec_WriteTo(b *testing.B) {
+	rb := New()
+	for key := uint32(0); key < 10000; key++ {
+		rb.Set(key<<16 | key%100)
+		rb.Set(key<<16 | 1000)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	var w countingWriter
+	for i := 0; i < b.N; i++ {
+		rb.WriteTo(&w)
+	}
+	b.ReportMetric(float64(w.writes)/float64(b.N), "writes/op")
+}
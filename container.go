@@ -3,11 +3,14 @@
 
 package roaring
 
+import "math/bits"
+
 const (
 	arrMinSize    = 2048
 	runMinSize    = 128
 	runMaxSize    = 2048
 	optimizeEvery = 2048
+	clearMaxSize  = 64 // Removals up to which bitmap bits are cleared one at a time
 )
 
 type ctype byte
@@ -18,9 +21,34 @@ const (
 	typeRun
 )
 
+// ContainerType is the representation of the container holding a key, as reported by Probe
+type ContainerType byte
+
+const (
+	ContainerNone   ContainerType = iota // No container holds the key
+	ContainerArray                       // Sorted array of values
+	ContainerBitmap                      // Bitmap of 65536 bits
+	ContainerRun                         // Sorted runs of consecutive values
+)
+
+// String returns the name of the container type
+func (t ContainerType) String() string {
+	switch t {
+	case ContainerArray:
+		return "array"
+	case ContainerBitmap:
+		return "bitmap"
+	case ContainerRun:
+		return "run"
+	default:
+		return "none"
+	}
+}
+
 type container struct {
 	Type   ctype  // Type of the container
 	Shared bool   // COW: true if data is shared between containers
+	Pinned bool   // Representation is pinned and never changed by optimization
 	Call   uint16 // Call count
 	Size   uint32 // Cardinality
 	Data   []uint16
@@ -28,7 +56,11 @@ type container struct {
 
 // fork ensures the container owns its data before modification
 func (c *container) fork() {
-	if c.Shared {
+	switch {
+	case c.Type == typeBitmap && len(c.Data) < bitmapSize:
+		c.Data = bmpPad(c.Data) // Malformed bitmap, padded before it is written to
+		c.Shared = false
+	case c.Shared:
 		clone := make([]uint16, len(c.Data), cap(c.Data))
 		copy(clone, c.Data)
 		c.Data = clone
@@ -36,43 +68,59 @@ func (c *container) fork() {
 	}
 }
 
+// share marks the container as shared since its data is about to be aliased and returns
+// a copy of it. Containers already shared are never written to, so read-only operands
+// such as clones can safely be used concurrently.
+func (c *container) share() container {
+	if !c.Shared {
+		c.Shared = true
+	}
+	return *c
+}
+
 // set sets a value in the container and returns true if the value was added (didn't exist before)
-func (c *container) set(value uint16) (ok bool) {
+func (c *container) set(value uint16, o *Options) (ok bool) {
 	c.fork()
+	defer c.repin(c.Type)
 	switch c.Type {
 	case typeArray:
 		if ok = c.arrSet(value); ok {
-			c.tryOptimize()
+			c.tryOptimize(o)
 		}
 	case typeBitmap:
 		if ok = c.bmpSet(value); ok {
-			c.tryOptimize()
+			c.tryOptimize(o)
 		}
 	case typeRun:
 		if ok = c.runSet(value); ok {
-			c.tryOptimize()
+			c.tryOptimize(o)
 		}
 	}
+
+	c.assertValid("set")
 	return
 }
 
 // remove removes a value from the container and returns true if the value was removed (existed before)
-func (c *container) remove(value uint16) (ok bool) {
+func (c *container) remove(value uint16, o *Options) (ok bool) {
 	c.fork()
+	defer c.repin(c.Type)
 	switch c.Type {
 	case typeArray:
 		if ok = c.arrDel(value); ok {
-			c.tryOptimize()
+			c.tryOptimize(o)
 		}
 	case typeBitmap:
 		if ok = c.bmpDel(value); ok {
-			c.tryOptimize()
+			c.tryOptimize(o)
 		}
 	case typeRun:
 		if ok = c.runDel(value); ok {
-			c.tryOptimize()
+			c.tryOptimize(o)
 		}
 	}
+
+	c.assertValid("remove")
 	return
 }
 
@@ -94,23 +142,130 @@ func (c *container) isEmpty() bool {
 	return c.Size == 0
 }
 
-// optimize converts the container to the most efficient representation
-func (c *container) optimize() {
+// cardinality computes the number of values in the container from its data
+func (c *container) cardinality() uint32 {
+	size := uint32(0)
+	switch c.Type {
+	case typeArray:
+		size = uint32(len(c.Data))
+	case typeBitmap:
+		for _, v := range c.Data {
+			size += uint32(bits.OnesCount16(v))
+		}
+	case typeRun:
+		// Widen before adding one, since a full run [0, 65535] holds 65536 values
+		for i := 0; i+1 < len(c.Data); i += 2 {
+			size += uint32(c.Data[i+1]-c.Data[i]) + 1
+		}
+	}
+	return size
+}
+
+// optimize converts the container to the most efficient representation, unless pinned
+func (c *container) optimize(o *Options) {
+	if c.Pinned {
+		return
+	}
+
 	c.fork()
 	switch c.Type {
 	case typeArray:
-		c.arrOptimize()
+		c.arrOptimize(o)
 	case typeBitmap:
-		c.bmpOptimize()
+		c.bmpOptimize(o)
 	case typeRun:
 		c.runOptimize()
 	}
 }
 
-// tryOptimize optimizes the container periodically
-func (c *container) tryOptimize() {
-	if c.Call++; c.Call%optimizeEvery == 0 {
-		c.optimize()
+// repin converts the container back to the given type if it is pinned and a kernel has
+// changed its representation, for instance an array turned into a bitmap when growing.
+func (c *container) repin(typ ctype) {
+	if c.Pinned && c.Type != typ && c.Size > 0 {
+		c.convert(typ)
+	}
+}
+
+// canonicalize converts the container to the representation with the smallest encoding,
+// preferring runs, then arrays, then bitmaps when equal. Since it depends only on the
+// values, equal containers always end up with identical types and data.
+func (c *container) canonicalize() {
+	size, runs := int(c.Size), c.runCount()
+	typ := typeBitmap
+	switch {
+	case runs*4 <= min(size*2, bitmapSize*2):
+		typ = typeRun
+	case size*2 <= bitmapSize*2:
+		typ = typeArray
+	}
+
+	// Runs are rebuilt unless already maximal
+	if c.Type == typ && (typ != typeRun || runs == len(c.Data)/2) {
+		return
+	}
+
+	c.convert(typ)
+}
+
+// convert converts the container to the given representation, regardless of whether
+// it is worth it. Everything goes through an array, and runs are always maximal.
+func (c *container) convert(typ ctype) {
+	c.fork()
+	switch c.Type {
+	case typeBitmap:
+		c.bmpToArr()
+	case typeRun:
+		c.runToArray()
+	}
+
+	switch typ {
+	case typeBitmap:
+		c.arrToBmp()
+	case typeRun:
+		runs := c.appendRuns(nil)
+		c.Data = c.Data[:0]
+		for _, r := range runs {
+			c.Data = append(c.Data, r[0], r[1])
+		}
+		c.Type = typeRun
+	}
+}
+
+// runCount returns the number of runs of consecutive values in the container
+func (c *container) runCount() int {
+	runs := 0
+	switch c.Type {
+	case typeArray:
+		for i := range c.Data {
+			if i == 0 || c.Data[i] != c.Data[i-1]+1 {
+				runs++
+			}
+		}
+	case typeBitmap:
+		carry := uint64(0)
+		for _, w := range c.bmp() {
+			runs += bits.OnesCount64(w &^ (w<<1 | carry))
+			carry = w >> 63
+		}
+	case typeRun:
+		for i := 0; i+1 < len(c.Data); i += 2 {
+			if i == 0 || uint32(c.Data[i]) != uint32(c.Data[i-1])+1 {
+				runs++
+			}
+		}
+	}
+	return runs
+}
+
+// tryOptimize optimizes the container periodically, unless disabled by the options
+func (c *container) tryOptimize(o *Options) {
+	n := o.interval()
+	if n == 0 {
+		return
+	}
+
+	if c.Call++; c.Call%n == 0 {
+		c.optimize(o)
 	}
 }
 
@@ -140,6 +295,19 @@ func (c *container) max() (uint16, bool) {
 	return 0, false
 }
 
+// nth returns the k-th smallest value in the container, counting from zero
+func (c *container) nth(k uint32) (uint16, bool) {
+	switch c.Type {
+	case typeArray:
+		return c.arrNth(k)
+	case typeBitmap:
+		return c.bmpNth(k)
+	case typeRun:
+		return c.runNth(k)
+	}
+	return 0, false
+}
+
 // minZero returns the smallest unset value in the container (0-65535 range)
 func (c *container) minZero() (uint16, bool) {
 	switch c.Type {
@@ -152,3 +320,29 @@ func (c *container) minZero() (uint16, bool) {
 	}
 	return 0, false
 }
+
+// nextZero returns the smallest unset value in the container which is at least lo
+func (c *container) nextZero(lo uint16) (uint16, bool) {
+	switch c.Type {
+	case typeArray:
+		return c.arrNextZero(lo)
+	case typeBitmap:
+		return c.bmpNextZero(lo)
+	case typeRun:
+		return c.runNextZero(lo)
+	}
+	return 0, false
+}
+
+// maxZero returns the largest unset value in the container (0-65535 range)
+func (c *container) maxZero() (uint16, bool) {
+	switch c.Type {
+	case typeArray:
+		return c.arrMaxZero()
+	case typeBitmap:
+		return c.bmpMaxZero()
+	case typeRun:
+		return c.runMaxZero()
+	}
+	return 0, false
+}
@@ -43,48 +43,59 @@ func (c *container) arrHas(value uint16) bool {
 }
 
 // arrOptimize tries to optimize the container
-func (c *container) arrOptimize() {
+func (c *container) arrOptimize(o *Options) {
 	switch {
-	case c.arrIsDense():
-		c.arrToRun()
+	case c.arrIsDense(o):
+		c.arrToRun(o)
 	case c.Size > arrMinSize:
 		c.arrToBmp()
 	}
+
+	if c.Type == typeArray {
+		c.arrCompact()
+	}
+}
+
+// arrCompact releases the unused capacity of an array which has shrunk considerably
+func (c *container) arrCompact() {
+	if cap(c.Data) > 4*len(c.Data) {
+		c.Data = append(make([]uint16, 0, len(c.Data)), c.Data...)
+	}
 }
 
-// arrIsDense quickly estimates if converting to run container would be beneficial
-func (c *container) arrIsDense() bool {
+// arrIsDense checks if converting to run container would be beneficial
+func (c *container) arrIsDense(o *Options) bool {
 	if len(c.Data) < 128 {
 		return false
 	}
 
 	lo, hi := c.Data[0], c.Data[len(c.Data)-1]
-	span := int(hi - lo + 1)
+	span := int(hi) - int(lo) + 1
 	size := len(c.Data)
 
 	// Quick density filters
 	density := float64(size) / float64(span)
 	switch {
-	case density < 0.1: // Very sparse
+	case density < o.runSparse(): // Very sparse
 		return false
-	case density > 0.8: // Very dense
+	case density > o.runDense(): // Very dense
 		return true
 	}
 
-	// Estimate number of runs using density
-	runs := size
-	if gap := float64(span) / float64(size); gap < 2.0 {
-		runs = int(float64(size) * (1.0 - density*0.7))
+	// Count the runs exactly, since estimating them from the density alone
+	// rejects arrays made of medium-length runs.
+	runs := 1
+	for i := 1; i < size; i++ {
+		if c.Data[i] != c.Data[i-1]+1 {
+			runs++
+		}
 	}
 
-	// Check if estimated conversion meets our criteria
-	sizeAsArr := size * 2
-	sizeAsRun := runs*4 + 2
-	return sizeAsRun < sizeAsArr*3/4 && runs <= size/3
+	return o.runFriendly(size, runs)
 }
 
 // arrToRun attempts to convert array to run in a single pass
-func (c *container) arrToRun() bool {
+func (c *container) arrToRun(o *Options) bool {
 	if len(c.Data) == 0 {
 		return false
 	}
@@ -109,14 +120,8 @@ func (c *container) arrToRun() bool {
 	// Add the final run
 	runsData = append(runsData, i0, i1)
 
-	// Check conversion criteria with the actual run count
-	numRuns := len(runsData) / 2
-	sizeAsArray := len(c.Data) * 2
-	sizeAsRun := numRuns*4 + 2 // 2 uint16 per run = 4 bytes
-
 	// Only convert if we save at least 25% space and have reasonable compression
-	shouldConvert := sizeAsRun < sizeAsArray*3/4 && numRuns <= len(c.Data)/3
-	if shouldConvert {
+	if o.runFriendly(len(c.Data), len(runsData)/2) {
 		c.Data = runsData
 		c.Type = typeRun
 		return true
@@ -156,6 +161,14 @@ func (c *container) arrMax() (uint16, bool) {
 	return c.Data[len(c.Data)-1], true
 }
 
+// arrNth returns the k-th smallest value in an array container
+func (c *container) arrNth(k uint32) (uint16, bool) {
+	if k >= uint32(len(c.Data)) {
+		return 0, false
+	}
+	return c.Data[k], true
+}
+
 // arrMinZero returns the smallest unset value in an array container
 func (c *container) arrMinZero() (uint16, bool) {
 	switch {
@@ -179,3 +192,45 @@ func (c *container) arrMinZero() (uint16, bool) {
 
 	return 0, false
 }
+
+// arrNextZero returns the smallest unset value in an array container which is at least lo
+func (c *container) arrNextZero(lo uint16) (uint16, bool) {
+	i, found := find16(c.Data, lo)
+	if !found {
+		return lo, true
+	}
+
+	// Skip the consecutive values following lo
+	for ; i+1 < len(c.Data) && c.Data[i+1] == c.Data[i]+1; i++ {
+	}
+
+	if last := c.Data[i]; last < 0xFFFF {
+		return last + 1, true
+	}
+	return 0, false
+}
+
+// arrMaxZero returns the largest unset value in an array container
+func (c *container) arrMaxZero() (uint16, bool) {
+	n := len(c.Data)
+	switch {
+	case n == 0:
+		return 0xFFFF, true
+	case c.Data[n-1] != 0xFFFF:
+		return 0xFFFF, true
+	}
+
+	// Find last gap in the sorted array
+	for i := n - 1; i > 0; i-- {
+		if c.Data[i-1] != c.Data[i]-1 {
+			return c.Data[i] - 1, true
+		}
+	}
+
+	// No gaps found, check if we can decrement the first element
+	if first := c.Data[0]; first > 0 {
+		return first - 1, true
+	}
+
+	return 0, false
+}
@@ -4,14 +4,28 @@
 package roaring
 
 import (
+	"math/bits"
+
 	"github.com/kelindar/bitmap"
 )
 
-// bmp converts the container to a bmp.Bitmap
+// bmp converts the container to a bmp.Bitmap. The view never modifies the container, so a
+// malformed (short) payload is viewed through a padded copy, while fork pads it for writes.
 func (c *container) bmp() bitmap.Bitmap {
+	if len(c.Data) < bitmapSize {
+		return asBitmap(bmpPad(c.Data))
+	}
 	return asBitmap(c.Data)
 }
 
+// bmpPad copies a short bitmap payload into a fresh slice covering the 65536 bits of a
+// container, so that the bitmap view never has fewer than 1024 words.
+func bmpPad(data []uint16) []uint16 {
+	out := make([]uint16, bitmapSize)
+	copy(out, data)
+	return out
+}
+
 // bmpSet sets a value in a bitmap container
 func (c *container) bmpSet(value uint16) bool {
 	if b := c.bmp(); !b.Contains(uint32(value)) {
@@ -44,12 +58,138 @@ func (c *container) bmpHas(value uint16) bool {
 	return c.bmp().Contains(uint32(value))
 }
 
+// bmpClearRange clears all of the bits in the closed interval [start, end] word by word
+func bmpClearRange(b bitmap.Bitmap, start, end uint32) {
+	lo, hi := start>>6, end>>6
+	loMask := ^uint64(0) << (start & 63)
+	hiMask := ^uint64(0) >> (63 - end&63)
+	if lo == hi {
+		b[lo] &^= loMask & hiMask
+		return
+	}
+
+	b[lo] &^= loMask
+	for i := lo + 1; i < hi; i++ {
+		b[i] = 0
+	}
+	b[hi] &^= hiMask
+}
+
+// bmpFlipRange toggles all of the bits in the closed interval [start, end] word by word
+// and returns the change in the number of bits set
+func bmpFlipRange(b bitmap.Bitmap, start, end uint32) int {
+	lo, hi := start>>6, end>>6
+	loMask := ^uint64(0) << (start & 63)
+	hiMask := ^uint64(0) >> (63 - end&63)
+	if lo == hi {
+		loMask &= hiMask
+	}
+
+	// Each flipped word gains its cleared bits and loses its set bits
+	delta := 0
+	for i := lo; i <= hi; i++ {
+		mask := ^uint64(0)
+		switch i {
+		case lo:
+			mask = loMask
+		case hi:
+			mask = hiMask
+		}
+
+		delta += bits.OnesCount64(mask) - 2*bits.OnesCount64(b[i]&mask)
+		b[i] ^= mask
+	}
+	return delta
+}
+
+// bmpCountRange counts the bits set in the closed interval [start, end] word by word
+func bmpCountRange(b bitmap.Bitmap, start, end uint32) int {
+	lo, hi := start>>6, end>>6
+	loMask := ^uint64(0) << (start & 63)
+	hiMask := ^uint64(0) >> (63 - end&63)
+	if lo == hi {
+		return bits.OnesCount64(b[lo] & loMask & hiMask)
+	}
+
+	count := bits.OnesCount64(b[lo]&loMask) + bits.OnesCount64(b[hi]&hiMask)
+	for i := lo + 1; i < hi; i++ {
+		count += bits.OnesCount64(b[i])
+	}
+	return count
+}
+
+// bmpFullRange checks whether all of the bits in the closed interval [start, end] are set
+func bmpFullRange(b bitmap.Bitmap, start, end uint32) bool {
+	lo, hi := start>>6, end>>6
+	loMask := ^uint64(0) << (start & 63)
+	hiMask := ^uint64(0) >> (63 - end&63)
+	if lo == hi {
+		return b[lo]&(loMask&hiMask) == loMask&hiMask
+	}
+
+	if b[lo]&loMask != loMask || b[hi]&hiMask != hiMask {
+		return false
+	}
+
+	for i := lo + 1; i < hi; i++ {
+		if b[i] != ^uint64(0) {
+			return false
+		}
+	}
+	return true
+}
+
+// bmpOrCount computes a |= b word by word and returns the number of bits set in the
+// result, counting them in the same pass rather than recounting the whole bitmap.
+func bmpOrCount(a, b bitmap.Bitmap) int {
+	count, b := 0, b[:len(a)]
+	for i := range a {
+		w := a[i] | b[i]
+		a[i] = w
+		count += bits.OnesCount64(w)
+	}
+	return count
+}
+
+// bmpAndCount computes a &= b word by word and returns the number of bits set in the result
+func bmpAndCount(a, b bitmap.Bitmap) int {
+	count, b := 0, b[:len(a)]
+	for i := range a {
+		w := a[i] & b[i]
+		a[i] = w
+		count += bits.OnesCount64(w)
+	}
+	return count
+}
+
+// bmpAndNotCount computes a &^= b word by word and returns the number of bits set in the result
+func bmpAndNotCount(a, b bitmap.Bitmap) int {
+	count, b := 0, b[:len(a)]
+	for i := range a {
+		w := a[i] &^ b[i]
+		a[i] = w
+		count += bits.OnesCount64(w)
+	}
+	return count
+}
+
+// bmpXorCount computes a ^= b word by word and returns the number of bits set in the result
+func bmpXorCount(a, b bitmap.Bitmap) int {
+	count, b := 0, b[:len(a)]
+	for i := range a {
+		w := a[i] ^ b[i]
+		a[i] = w
+		count += bits.OnesCount64(w)
+	}
+	return count
+}
+
 // bmpOptimize tries to optimize the container
-func (c *container) bmpOptimize() {
+func (c *container) bmpOptimize(o *Options) {
 	switch {
 	case c.bmpIsDense():
 		c.bmpToRun()
-	case c.Size <= arrMinSize:
+	case c.Size <= o.arrayMin():
 		c.bmpToArr()
 	}
 }
@@ -174,6 +314,24 @@ func (c *container) bmpMax() (uint16, bool) {
 	return 0, false
 }
 
+// bmpNth returns the k-th smallest value in a bitmap container, skipping whole words by
+// their popcount and then clearing the lowest bits of the word holding the value
+func (c *container) bmpNth(k uint32) (uint16, bool) {
+	for i, w := range c.bmp() {
+		n := uint32(bits.OnesCount64(w))
+		if k >= n {
+			k -= n
+			continue
+		}
+
+		for ; k > 0; k-- {
+			w &= w - 1
+		}
+		return uint16(i*64 + bits.TrailingZeros64(w)), true
+	}
+	return 0, false
+}
+
 // bmpMinZero returns the smallest unset value in a bitmap container
 func (c *container) bmpMinZero() (uint16, bool) {
 	bmp := c.bmp()
@@ -183,3 +341,27 @@ func (c *container) bmpMinZero() (uint16, bool) {
 	}
 	return uint16(v), true
 }
+
+// bmpNextZero returns the smallest unset value in a bitmap container which is at least lo
+func (c *container) bmpNextZero(lo uint16) (uint16, bool) {
+	bmp := c.bmp()
+	i := int(lo >> 6)
+	w := ^bmp[i] & (^uint64(0) << (lo & 63))
+	for w == 0 {
+		if i++; i >= len(bmp) {
+			return 0, false
+		}
+		w = ^bmp[i]
+	}
+	return uint16(i*64 + bits.TrailingZeros64(w)), true
+}
+
+// bmpMaxZero returns the largest unset value in a bitmap container
+func (c *container) bmpMaxZero() (uint16, bool) {
+	bmp := c.bmp()
+	v, ok := bmp.MaxZero()
+	if !ok {
+		return 0, false
+	}
+	return uint16(v), true
+}
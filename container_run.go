@@ -44,6 +44,16 @@ func (c *container) runFind(value uint16) (idx [2]int, ok bool) {
 	return [2]int{hi, hi}, false
 }
 
+// runSeek advances the position j of a run, given as an index into the run data, to the
+// first run ending at or after the value and reports whether that run covers the value.
+// Since the runs are sorted, probing ascending values this way never searches twice.
+func runSeek(runs []uint16, j int, value uint16) (int, bool) {
+	for j < len(runs) && runs[j+1] < value {
+		j += 2
+	}
+	return j, j < len(runs) && runs[j] <= value
+}
+
 // runSet sets a value in a run container
 func (c *container) runSet(value uint16) bool {
 	search, found := c.runFind(value)
@@ -66,6 +76,10 @@ func (c *container) runSet(value uint16) bool {
 		c.Data[(idx-1)*2+1] = value
 	case canMergeRight:
 		c.Data[idx*2] = value
+	case numRuns >= runMaxSize:
+		// Too many runs, a bitmap is more compact from here on
+		c.runToBmp()
+		return c.bmpSet(value)
 	default:
 		c.runInsertRunAt(idx, value, value)
 	}
@@ -91,6 +105,10 @@ func (c *container) runDel(value uint16) bool {
 		c.Data[idx*2] = value + 1
 	case value == r1:
 		c.Data[idx*2+1] = value - 1
+	case len(c.Data)/2 >= runMaxSize:
+		// Splitting would exceed the run limit, a bitmap is more compact from here on
+		c.runToBmp()
+		return c.bmpDel(value)
 	default:
 		c.Data[idx*2+1] = value - 1
 		c.runInsertRunAt(idx+1, value+1, r1)
@@ -118,8 +136,9 @@ func (c *container) runInsertRunAt(index int, start, end uint16) {
 			copy(c.Data[(index+1)*2:], c.Data[index*2:numRuns*2])
 		}
 	} else {
-		// Need to allocate new slice with extra capacity for future insertions
-		extraCapacity := max(16, numRuns) // Add 50% extra capacity or minimum 8 runs
+		// Need to allocate new slice with extra capacity for future insertions, but never
+		// beyond the run limit since the container converts to a bitmap past it.
+		extraCapacity := max(0, min(max(16, numRuns), runMaxSize*2-newLen))
 		newData := make([]uint16, newLen, newLen+extraCapacity)
 
 		// Copy existing runs with efficient bulk operations
@@ -233,6 +252,18 @@ func (c *container) runMax() (uint16, bool) {
 	return c.Data[len(c.Data)-1], true // Last run's end
 }
 
+// runNth returns the k-th smallest value in a run container, accumulating run lengths
+func (c *container) runNth(k uint32) (uint16, bool) {
+	for i := 0; i+1 < len(c.Data); i += 2 {
+		n := uint32(c.Data[i+1]) - uint32(c.Data[i]) + 1
+		if k < n {
+			return c.Data[i] + uint16(k), true
+		}
+		k -= n
+	}
+	return 0, false
+}
+
 // runMinZero returns the smallest unset value in a run container
 func (c *container) runMinZero() (uint16, bool) {
 	switch {
@@ -260,3 +291,51 @@ func (c *container) runMinZero() (uint16, bool) {
 
 	return 0, false
 }
+
+// runNextZero returns the smallest unset value in a run container which is at least lo
+func (c *container) runNextZero(lo uint16) (uint16, bool) {
+	v := uint32(lo)
+	for i := 0; i+1 < len(c.Data); i += 2 {
+		start, end := uint32(c.Data[i]), uint32(c.Data[i+1])
+		switch {
+		case end < v:
+			continue
+		case start > v:
+			return uint16(v), true
+		default: // Skip past the run, which may be followed by an adjacent one
+			v = end + 1
+		}
+	}
+
+	if v > 0xFFFF {
+		return 0, false
+	}
+	return uint16(v), true
+}
+
+// runMaxZero returns the largest unset value in a run container
+func (c *container) runMaxZero() (uint16, bool) {
+	n := len(c.Data) / 2
+	switch {
+	case n == 0:
+		return 0xFFFF, true
+	case c.Data[n*2-1] < 65535:
+		return 0xFFFF, true
+	}
+
+	// Find last gap between runs
+	for i := n - 1; i > 0; i-- {
+		r0 := c.Data[(i-1)*2+1]
+		r1 := c.Data[i*2]
+		if r1 > r0+1 {
+			return r1 - 1, true
+		}
+	}
+
+	// Check if there's a gap before the first run
+	if firstStart := c.Data[0]; firstStart > 0 {
+		return firstStart - 1, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+//go:build roaringdebug
+
+package roaring
+
+import "fmt"
+
+// assertValid panics if the bitmap is left inconsistent by the given operation. This is
+// only enabled with the roaringdebug build tag, so that kernel bugs surface immediately.
+func (rb *Bitmap) assertValid(op string) {
+	if len(rb.index) != len(rb.containers) {
+		panic(fmt.Sprintf("roaring: %s left %d keys for %d containers", op, len(rb.index), len(rb.containers)))
+	}
+
+	for i := range rb.containers {
+		if i > 0 && rb.index[i-1] >= rb.index[i] {
+			panic(fmt.Sprintf("roaring: %s left the key %d out of order", op, rb.index[i]))
+		}
+
+		rb.containers[i].assertValid(op)
+	}
+}
+
+// assertInsert panics if a container with the given key cannot be inserted at the given
+// position, since a duplicate or misplaced key silently breaks every lookup of the index.
+func (rb *Bitmap) assertInsert(hi uint16, pos int) {
+	if pos > 0 && rb.index[pos-1] >= hi || pos < len(rb.index) && rb.index[pos] <= hi {
+		panic(fmt.Sprintf("roaring: key %d inserted at %d is a duplicate or out of order", hi, pos))
+	}
+}
+
+// assertValid panics if the container is left inconsistent by the given operation. An
+// empty container is allowed, since the bitmap removes it right after the operation.
+func (c *container) assertValid(op string) {
+	if c.Size == 0 && c.cardinality() == 0 {
+		return
+	}
+
+	if err := c.validate(); err != nil {
+		panic(fmt.Sprintf("roaring: %s left the container invalid, %v", op, err))
+	}
+}
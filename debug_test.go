@@ -0,0 +1,61 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+//go:build roaringdebug
+
+package roaring
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugAssertions(t *testing.T) {
+	t.Run("stale size", func(t *testing.T) {
+		rb := bitmapOf(newArr(1, 2, 3))
+		rb.containers[0].Size = 10
+		assert.PanicsWithValue(t, "roaring: set left the container invalid, size is 11, expected 4", func() {
+			rb.Set(4)
+		})
+	})
+
+	t.Run("unsorted array", func(t *testing.T) {
+		rb := bitmapOf(newArr(1, 2, 3))
+		rb.containers[0].Data[0] = 5
+		assert.Panics(t, func() { rb.Remove(2) })
+	})
+
+	t.Run("overlapping runs", func(t *testing.T) {
+		rb := bitmapOf(newRun(1, 2, 3, 10, 11))
+		rb.containers[0].Data = []uint16{1, 3, 2, 11}
+		assert.Panics(t, func() { rb.Or(bitmapOf(newRun(20, 21))) })
+	})
+
+	t.Run("out of order keys", func(t *testing.T) {
+		rb := FromArray([]uint32{1, 1 << 16})
+		rb.index[0], rb.index[1] = rb.index[1], rb.index[0]
+		assert.Panics(t, func() { rb.Xor(FromArray([]uint32{5 << 16})) })
+	})
+
+	t.Run("duplicate key", func(t *testing.T) {
+		rb := makeTestBitmap()
+		assert.PanicsWithValue(t, "roaring: key 1 inserted at 1 is a duplicate or out of order", func() {
+			rb.ctrAdd(1, 1, newArr(7))
+		})
+		assert.Panics(t, func() { rb.ctrAdd(3, 0, newArr(7)) })
+		assert.NotPanics(t, func() { rb.ctrAdd(3, 3, newArr(7)) })
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		rb := makeTestBitmap()
+		assert.NotPanics(t, func() {
+			rb.Set(7 << 16)
+			rb.Remove(1)
+			rb.And(makeTestBitmap())
+			rb.AndNot(FromArray([]uint32{5}))
+			rb.Or(makeTestBitmap())
+			rb.Xor(makeTestBitmap())
+		})
+	})
+}
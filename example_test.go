@@ -0,0 +1,20 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring_test
+
+import (
+	"fmt"
+
+	"github.com/kelindar/roaring"
+)
+
+// A handful of scattered values would normally be stored as an array, but a test can
+// force them into runs to exercise the code paths specific to run containers.
+func ExampleNewForTest() {
+	rb := roaring.NewForTest(roaring.ContainerRun, 1, 5, 10)
+	found, typ := rb.Probe(5)
+	fmt.Println(found, typ, rb.Count())
+
+	// Output: true run 3
+}
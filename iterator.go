@@ -0,0 +1,220 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import "math/bits"
+
+// Iterator yields values one at a time in ascending order, computing each of them only
+// when asked for. It remains valid as long as the underlying bitmaps are not mutated.
+type Iterator struct {
+	next    func() (uint32, bool) // Produces the next value, if any
+	many    func([]uint32) int    // Produces the next values in bulk, if supported
+	value   uint32                // The value produced but not yet returned
+	pending bool                  // Whether a value was produced but not yet returned
+	done    bool                  // Whether the values are exhausted
+}
+
+// HasNext checks whether there are values left to be returned by Next
+func (it *Iterator) HasNext() bool {
+	if !it.pending && !it.done {
+		it.value, it.pending = it.next()
+		it.done = !it.pending
+	}
+	return it.pending
+}
+
+// Next returns the next value, assuming HasNext reported that there is one
+func (it *Iterator) Next() uint32 {
+	it.HasNext()
+	it.pending = false
+	return it.value
+}
+
+// NextMany fills the buffer with up to len(buf) of the next values and returns how many
+// were written, which is less than len(buf) only once the values are exhausted.
+func (it *Iterator) NextMany(buf []uint32) int {
+	n := 0
+	if it.pending && len(buf) > 0 {
+		buf[0], it.pending = it.value, false
+		n++
+	}
+
+	if it.many != nil && !it.done {
+		return n + it.many(buf[n:])
+	}
+
+	for ; n < len(buf) && it.HasNext(); n++ {
+		buf[n] = it.Next()
+	}
+	return n
+}
+
+// Iterator returns an iterator over the values of the bitmap in ascending order. Unlike
+// Range, it can be paused between values and interleaved with other work, and decodes
+// the containers lazily as it advances.
+func (rb *Bitmap) Iterator() *Iterator {
+	cur := rb.cursor()
+	return &Iterator{next: cur.next, many: cur.nextMany}
+}
+
+// SymmetricDifference returns an iterator over the values present in exactly one of the
+// bitmaps, computed on the fly by co-advancing over both of them. Unlike Xor, it does
+// not build the result, so consuming only a prefix of it costs only as much.
+func SymmetricDifference(a, b *Bitmap) *Iterator {
+	x, y := a.cursor(), b.cursor()
+	vx, okx := x.next()
+	vy, oky := y.next()
+	return &Iterator{next: func() (uint32, bool) {
+		for okx && oky && vx == vy {
+			vx, okx = x.next()
+			vy, oky = y.next()
+		}
+
+		switch {
+		case okx && (!oky || vx < vy):
+			v := vx
+			vx, okx = x.next()
+			return v, true
+		case oky:
+			v := vy
+			vy, oky = y.next()
+			return v, true
+		default:
+			return 0, false
+		}
+	}}
+}
+
+// ComplementIterator returns an iterator over the values present in the universe but
+// absent from the bitmap, computed on the fly by co-advancing over both of them. Unlike
+// AndNot, it does not build the result, so consuming only a prefix of it costs only as much.
+func ComplementIterator(rb, universe *Bitmap) *Iterator {
+	x, u := rb.cursor(), universe.cursor()
+	vx, okx := x.next()
+	return &Iterator{next: func() (uint32, bool) {
+		for {
+			vu, oku := u.next()
+			for okx && oku && vx < vu {
+				vx, okx = x.next()
+			}
+
+			switch {
+			case !oku:
+				return 0, false
+			case !okx || vx != vu:
+				return vu, true
+			}
+		}
+	}}
+}
+
+// cursor walks over the values of a bitmap in ascending order, keeping track of its
+// position within the current container instead of decoding it upfront
+type cursor struct {
+	rb   *Bitmap
+	i    int    // Index of the current container
+	j    int    // Position in the array, next word of the bitmap or current run
+	word uint64 // Bits of the last bitmap word read which are yet to be returned
+	off  uint16 // Offset of the next value within the current run
+}
+
+// cursor returns a cursor positioned before the first value of the bitmap
+func (rb *Bitmap) cursor() *cursor {
+	return &cursor{rb: rb}
+}
+
+// next returns the next value of the bitmap, if any
+func (cur *cursor) next() (uint32, bool) {
+	for cur.rb != nil && cur.i < len(cur.rb.containers) {
+		c := &cur.rb.containers[cur.i]
+		base := uint32(cur.rb.index[cur.i]) << 16
+		switch c.Type {
+		case typeArray:
+			if cur.j < len(c.Data) {
+				cur.j++
+				return base | uint32(c.Data[cur.j-1]), true
+			}
+		case typeBitmap:
+			bmp := c.bmp()
+			for cur.word == 0 && cur.j < len(bmp) {
+				cur.word = bmp[cur.j]
+				cur.j++
+			}
+
+			if cur.word != 0 {
+				lo := (cur.j-1)*64 + bits.TrailingZeros64(cur.word)
+				cur.word &= cur.word - 1
+				return base | uint32(lo), true
+			}
+		case typeRun:
+			if cur.j+1 < len(c.Data) {
+				lo := c.Data[cur.j] + cur.off
+				if cur.off++; lo == c.Data[cur.j+1] {
+					cur.j, cur.off = cur.j+2, 0
+				}
+				return base | uint32(lo), true
+			}
+		}
+
+		// Move on to the next container
+		cur.i, cur.j, cur.word, cur.off = cur.i+1, 0, 0, 0
+	}
+	return 0, false
+}
+
+// nextMany fills the buffer with the next values of the bitmap, decoding whole words of
+// bitmaps and whole segments of runs at a time, and returns how many were written
+func (cur *cursor) nextMany(buf []uint32) int {
+	n := 0
+	for n < len(buf) && cur.rb != nil && cur.i < len(cur.rb.containers) {
+		c := &cur.rb.containers[cur.i]
+		base := uint32(cur.rb.index[cur.i]) << 16
+		switch c.Type {
+		case typeArray:
+			for ; cur.j < len(c.Data) && n < len(buf); cur.j, n = cur.j+1, n+1 {
+				buf[n] = base | uint32(c.Data[cur.j])
+			}
+		case typeBitmap:
+			bmp := c.bmp()
+			for n < len(buf) {
+				if cur.word == 0 {
+					if cur.j >= len(bmp) {
+						break
+					}
+
+					cur.word = bmp[cur.j]
+					cur.j++
+					continue
+				}
+
+				offset := base | uint32((cur.j-1)*64)
+				for ; cur.word != 0 && n < len(buf); n++ {
+					buf[n] = offset | uint32(bits.TrailingZeros64(cur.word))
+					cur.word &= cur.word - 1
+				}
+			}
+		case typeRun:
+			for cur.j+1 < len(c.Data) && n < len(buf) {
+				lo := uint32(c.Data[cur.j]) + uint32(cur.off)
+				count := min(int(uint32(c.Data[cur.j+1])-lo)+1, len(buf)-n)
+				for k := range count {
+					buf[n+k] = base | (lo + uint32(k))
+				}
+
+				n += count
+				if lo+uint32(count) > uint32(c.Data[cur.j+1]) {
+					cur.j, cur.off = cur.j+2, 0
+				} else {
+					cur.off += uint16(count)
+				}
+			}
+		}
+
+		// Move on to the next container once this one is exhausted
+		if n < len(buf) {
+			cur.i, cur.j, cur.word, cur.off = cur.i+1, 0, 0, 0
+		}
+	}
+	return n
+}
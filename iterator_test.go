@@ -0,0 +1,258 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSymmetricDifference(t *testing.T) {
+	gens := []dataGen{genSeq(1000, 0), genRand(10000, 1<<20), genSparse(1000), genDense(10000), genBoundary(), genMixed()}
+	for _, gen1 := range gens {
+		for _, gen2 := range gens {
+			data1, name1 := gen1()
+			data2, name2 := gen2()
+			t.Run(name1+" ⊕ "+name2, func(t *testing.T) {
+				a, b := FromArray(data1), FromArray(data2)
+				expect := a.Clone(nil)
+				expect.Xor(b)
+				values := expect.ToArray()
+
+				// Only a prefix of the values is consumed
+				for _, n := range []int{1, 10, len(values)/2 + 1} {
+					var out []uint32
+					it := SymmetricDifference(a, b)
+					for len(out) < n && it.HasNext() {
+						out = append(out, it.Next())
+					}
+					assert.Equal(t, values[:min(n, len(values))], out)
+				}
+
+				// All of the values are consumed
+				var out []uint32
+				for it := SymmetricDifference(a, b); it.HasNext(); {
+					out = append(out, it.Next())
+				}
+				assert.Equal(t, values, out)
+			})
+		}
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		rb := makeTestBitmap()
+		assert.False(t, SymmetricDifference(rb, rb).HasNext())
+		assert.False(t, SymmetricDifference(New(), nil).HasNext())
+
+		var out []uint32
+		for it := SymmetricDifference(nil, rb); it.HasNext(); {
+			out = append(out, it.Next())
+		}
+		assert.Equal(t, rb.ToArray(), out)
+	})
+
+	t.Run("repeated HasNext", func(t *testing.T) {
+		it := SymmetricDifference(FromArray([]uint32{1, 2, 3}), FromArray([]uint32{2, 4}))
+		assert.True(t, it.HasNext())
+		assert.True(t, it.HasNext())
+		assert.Equal(t, uint32(1), it.Next())
+		assert.Equal(t, uint32(3), it.Next())
+		assert.Equal(t, uint32(4), it.Next())
+		assert.False(t, it.HasNext())
+	})
+}
+
+func TestIterator(t *testing.T) {
+	gens := []dataGen{genSeq(1000, 0), genRand(10000, 1<<20), genSparse(1000), genDense(10000), genBoundary(), genMixed()}
+	for _, gen := range gens {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			for _, typ := range []ContainerType{ContainerArray, ContainerBitmap, ContainerRun} {
+				rb := NewForTest(typ, data...)
+				var expect []uint32
+				rb.Range(func(x uint32) bool {
+					expect = append(expect, x)
+					return true
+				})
+
+				var out []uint32
+				for it := rb.Iterator(); it.HasNext(); {
+					out = append(out, it.Next())
+				}
+				assert.Equal(t, expect, out, typ.String())
+			}
+		})
+	}
+
+	t.Run("full containers", func(t *testing.T) {
+		for _, typ := range []ContainerType{ContainerBitmap, ContainerRun} {
+			rb := NewForTest(typ, seq(65536, 3*65536, 1)...)
+			rb.Set(4294967295)
+
+			var out []uint32
+			for it := rb.Iterator(); it.HasNext(); {
+				out = append(out, it.Next())
+			}
+			assert.Equal(t, append(seq(65536, 3*65536, 1), 4294967295), out)
+		}
+	})
+
+	t.Run("interleaved", func(t *testing.T) {
+		a, b := makeTestBitmap(), makeTestBitmap()
+		x, y := a.Iterator(), b.Iterator()
+		for x.HasNext() {
+			assert.True(t, y.HasNext())
+			assert.Equal(t, x.Next(), y.Next())
+		}
+		assert.False(t, y.HasNext())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.False(t, New().Iterator().HasNext())
+
+		var rb *Bitmap
+		assert.False(t, rb.Iterator().HasNext())
+	})
+}
+
+func TestComplementIterator(t *testing.T) {
+	gens := []dataGen{genSeq(1000, 0), genRand(10000, 1<<20), genSparse(1000), genDense(10000), genBoundary(), genMixed()}
+	for _, gen1 := range gens {
+		for _, gen2 := range gens {
+			data1, name1 := gen1()
+			data2, name2 := gen2()
+			t.Run(name2+" \\ "+name1, func(t *testing.T) {
+				rb, universe := FromArray(data1), FromArray(data2)
+				expect := universe.Clone(nil)
+				expect.AndNot(rb)
+				values := expect.ToArray()
+
+				// Only a prefix of the values is consumed
+				for _, n := range []int{1, 10, len(values)/2 + 1} {
+					var out []uint32
+					it := ComplementIterator(rb, universe)
+					for len(out) < n && it.HasNext() {
+						out = append(out, it.Next())
+					}
+					assert.Equal(t, values[:min(n, len(values))], out)
+				}
+
+				// All of the values are consumed
+				var out []uint32
+				for it := ComplementIterator(rb, universe); it.HasNext(); {
+					out = append(out, it.Next())
+				}
+				assert.Equal(t, values, out)
+			})
+		}
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		rb := makeTestBitmap()
+		assert.False(t, ComplementIterator(rb, rb).HasNext())
+		assert.False(t, ComplementIterator(rb, nil).HasNext())
+		assert.False(t, ComplementIterator(nil, New()).HasNext())
+
+		var out []uint32
+		for it := ComplementIterator(nil, rb); it.HasNext(); {
+			out = append(out, it.Next())
+		}
+		assert.Equal(t, rb.ToArray(), out)
+	})
+
+	t.Run("unassigned", func(t *testing.T) {
+		universe := New()
+		universe.AddRange(0, 100)
+		it := ComplementIterator(FromArray(seq(0, 50, 1)), universe)
+		assert.Equal(t, uint32(50), it.Next())
+		assert.Equal(t, uint32(51), it.Next())
+	})
+}
+
+func TestIteratorNextMany(t *testing.T) {
+	gens := []dataGen{genSeq(1000, 0), genRand(10000, 1<<20), genSparse(1000), genDense(10000), genBoundary(), genMixed()}
+	for _, gen := range gens {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			for _, typ := range []ContainerType{ContainerArray, ContainerBitmap, ContainerRun} {
+				rb := NewForTest(typ, data...)
+				for _, size := range []int{1, 3, 64, 1000, 70000} {
+					var out []uint32
+					buf := make([]uint32, size)
+					it := rb.Iterator()
+					for n := it.NextMany(buf); n > 0; n = it.NextMany(buf) {
+						out = append(out, buf[:n]...)
+					}
+					assert.Equal(t, rb.ToArray(), out, "%s with %d", typ, size)
+					assert.False(t, it.HasNext())
+				}
+			}
+		})
+	}
+
+	t.Run("full containers", func(t *testing.T) {
+		for _, typ := range []ContainerType{ContainerBitmap, ContainerRun} {
+			rb := NewForTest(typ, seq(0, 2*65536, 1)...)
+			buf := make([]uint32, 65536)
+			it := rb.Iterator()
+			assert.Equal(t, 65536, it.NextMany(buf))
+			assert.Equal(t, seq(0, 65536, 1), buf)
+			assert.Equal(t, 65536, it.NextMany(buf))
+			assert.Equal(t, seq(65536, 2*65536, 1), buf)
+			assert.Equal(t, 0, it.NextMany(buf))
+		}
+	})
+
+	t.Run("mixed with next", func(t *testing.T) {
+		rb := makeTestBitmap()
+		buf := make([]uint32, 7)
+		it := rb.Iterator()
+
+		var out []uint32
+		for it.HasNext() {
+			out = append(out, it.Next())
+			n := it.NextMany(buf)
+			out = append(out, buf[:n]...)
+		}
+		assert.Equal(t, rb.ToArray(), out)
+	})
+
+	t.Run("without bulk", func(t *testing.T) {
+		a, b := FromArray([]uint32{1, 2, 3}), FromArray([]uint32{2, 4, 5})
+		buf := make([]uint32, 2)
+		it := SymmetricDifference(a, b)
+		assert.Equal(t, 2, it.NextMany(buf))
+		assert.Equal(t, []uint32{1, 3}, buf)
+		assert.Equal(t, 2, it.NextMany(buf))
+		assert.Equal(t, []uint32{4, 5}, buf)
+		assert.Equal(t, 0, it.NextMany(buf))
+		assert.Equal(t, 0, it.NextMany(nil))
+	})
+}
+
+func BenchmarkIterator(b *testing.B) {
+	data, _ := genMixed()()
+	rb := FromArray(data)
+
+	b.Run("next", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for it := rb.Iterator(); it.HasNext(); {
+				it.Next()
+			}
+		}
+	})
+
+	b.Run("next-many", func(b *testing.B) {
+		buf := make([]uint32, 1024)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for it := rb.Iterator(); it.NextMany(buf) > 0; {
+			}
+		}
+	})
+}
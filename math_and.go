@@ -11,30 +11,64 @@ func (rb *Bitmap) and(other *Bitmap) {
 		return
 	case len(rb.containers) == 0:
 		return
+	case rb.index[len(rb.index)-1] < other.index[0] || rb.index[0] > other.index[len(other.index)-1]:
+		rb.Clear() // Disjoint key ranges
+		return
 	}
 
-	// Iterate through all containers in this bitmap
-	rb.scratch = rb.scratch[:0]
+	// Iterate through all containers in this bitmap, compacting the non-empty ones
+	n := 0
 	for i := range rb.containers {
 		c1 := &rb.containers[i]
 		idx, exists := find16(other.index, rb.index[i])
-		switch {
-		case !exists:
-			rb.scratch = append(rb.scratch, uint16(i))
-		case !rb.ctrAnd(c1, &other.containers[idx]):
-			rb.scratch = append(rb.scratch, uint16(i))
+		if !exists || !rb.ctrAnd(c1, &other.containers[idx]) {
+			continue
 		}
+
+		rb.containers[n] = rb.containers[i]
+		rb.index[n] = rb.index[i]
+		n++
 	}
 
-	// Batch remove empty containers (in reverse order to maintain indices)
-	for i := len(rb.scratch) - 1; i >= 0; i-- {
-		rb.ctrDel(int(rb.scratch[i]))
+	rb.containers = rb.containers[:n]
+	rb.index = rb.index[:n]
+}
+
+// Intersection returns a new bitmap with the values present in both bitmaps, along with
+// its cardinality computed in the same pass. Neither of the bitmaps is modified.
+func (rb *Bitmap) Intersection(other *Bitmap) (*Bitmap, int) {
+	out := &Bitmap{opts: rb.opts}
+	if other == nil {
+		return out, 0
 	}
+
+	count := 0
+	for i, j := 0, 0; i < len(rb.index) && j < len(other.index); {
+		switch hi1, hi2 := rb.index[i], other.index[j]; {
+		case hi1 < hi2:
+			i++
+		case hi1 > hi2:
+			j++
+		default:
+			// Work on a shared copy, so the kernel forks it instead of modifying ours
+			c := rb.containers[i]
+			c.Shared = true
+			if out.ctrAnd(&c, &other.containers[j]) {
+				out.containers = append(out.containers, c)
+				out.index = append(out.index, hi1)
+				count += int(c.Size)
+			}
+			i++
+			j++
+		}
+	}
+	return out, count
 }
 
 // and performs efficient AND between two containers
 func (rb *Bitmap) ctrAnd(c1, c2 *container) bool {
 	c1.fork()
+	defer c1.repin(c1.Type)
 	switch c1.Type {
 	case typeArray:
 		switch c2.Type {
@@ -146,6 +180,10 @@ func (rb *Bitmap) bmpAndArr(c1, c2 *container) bool {
 	c1.Data = append(c1.Data[:0], out...)
 	c1.Size = uint32(len(c1.Data))
 	c1.Type = typeArray
+	rb.scratch = out
+
+	// Large or contiguous results are better represented otherwise
+	c1.optimize(rb.opts)
 	return c1.Size > 0
 }
 
@@ -156,8 +194,7 @@ func (rb *Bitmap) bmpAndBmp(c1, c2 *container) bool {
 		return false
 	}
 
-	a.And(b)
-	c1.Size = uint32(a.Count())
+	c1.Size = uint32(bmpAndCount(a, b))
 	return c1.Size > 0
 }
 
@@ -209,6 +246,10 @@ func (rb *Bitmap) runAndArr(c1, c2 *container) bool {
 	c1.Data = append(c1.Data[:0], out...)
 	c1.Size = uint32(len(out))
 	c1.Type = typeArray
+	rb.scratch = out
+
+	// Large or contiguous results are better represented otherwise
+	c1.optimize(rb.opts)
 	return c1.Size > 0
 }
 
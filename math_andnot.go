@@ -3,6 +3,8 @@
 
 package roaring
 
+import "slices"
+
 // andNot performs AND NOT with a single bitmap efficiently
 func (rb *Bitmap) andNot(other *Bitmap) {
 	switch {
@@ -12,30 +14,84 @@ func (rb *Bitmap) andNot(other *Bitmap) {
 		return // Empty bitmap AND NOT anything = empty
 	}
 
-	// Remove elements that are in other bitmap
-	rb.scratch = rb.scratch[:0]
+	// Remove elements that are in other bitmap, compacting the non-empty containers. Only
+	// the containers of this bitmap are visited and the keys are searched in a narrowing
+	// window of the other index, so the cost does not depend on the size of the other.
+	n, pos := 0, 0
 	for i := range rb.containers {
 		c1 := &rb.containers[i]
-		idx, exists := find16(other.index, rb.index[i])
-		switch {
-		case !exists:
-			// Container not in other bitmap - keep as is
-			continue
-		case !rb.ctrAndNot(c1, &other.containers[idx]):
-			// Container became empty - mark for removal
-			rb.scratch = append(rb.scratch, uint16(i))
+		idx, exists := find16(other.index[pos:], rb.index[i])
+		if pos += idx; exists && !rb.ctrAndNot(c1, &other.containers[pos]) {
+			continue // Container became empty - drop it
 		}
+
+		rb.containers[n] = rb.containers[i]
+		rb.index[n] = rb.index[i]
+		n++
+	}
+
+	rb.containers = rb.containers[:n]
+	rb.index = rb.index[:n]
+}
+
+// AndNotValues removes a slice of values, ideally sorted in ascending order, from the
+// bitmap. Values sharing the same high bits are removed from their container at once, and
+// the containers which become empty are dropped together in a single compacting pass. The
+// slice is left untouched, and is copied to be sorted if it is not sorted already.
+func (rb *Bitmap) AndNotValues(values []uint32) {
+	if !slices.IsSorted(values) {
+		values = slices.Clone(values)
+		slices.Sort(values)
 	}
 
-	// Batch remove empty containers (in reverse order to maintain indices)
-	for i := len(rb.scratch) - 1; i >= 0; i-- {
-		rb.ctrDel(int(rb.scratch[i]))
+	lows := borrowArray()
+	n, pos := 0, 0 // Next position to keep a container at, and to search the index from
+	for i := 0; i < len(values) && pos < len(rb.containers); {
+		hi := uint16(values[i] >> 16)
+
+		// Collect the low bits of this group, skipping duplicates
+		lows = lows[:0]
+		for ; i < len(values) && uint16(values[i]>>16) == hi; i++ {
+			if k, lo := len(lows), uint16(values[i]&0xFFFF); k == 0 || lows[k-1] != lo {
+				lows = append(lows, lo)
+			}
+		}
+
+		idx, exists := find16(rb.index[pos:], hi)
+		if !exists {
+			continue
+		}
+
+		// Keep the untouched containers preceding this one, shifted over the dropped ones
+		idx += pos
+		n, pos = rb.ctrShift(n, pos, idx), idx+1
+		if rb.ctrAndNotValues(&rb.containers[idx], lows) {
+			rb.containers[n] = rb.containers[idx]
+			rb.index[n] = rb.index[idx]
+			n++
+		}
 	}
+	release(lows)
+
+	n = rb.ctrShift(n, pos, len(rb.containers))
+	rb.containers = rb.containers[:n]
+	rb.index = rb.index[:n]
+}
+
+// ctrAndNotValues removes sorted low bits from the container, returning whether it still
+// contains any values.
+func (rb *Bitmap) ctrAndNotValues(c *container, lows []uint16) bool {
+	return rb.ctrAndNot(c, &container{
+		Type: typeArray,
+		Size: uint32(len(lows)),
+		Data: lows,
+	})
 }
 
 // ctrAndNot performs efficient AND NOT between two containers
 func (rb *Bitmap) ctrAndNot(c1, c2 *container) bool {
 	c1.fork()
+	defer c1.repin(c1.Type)
 	switch c1.Type {
 	case typeArray:
 		switch c2.Type {
@@ -123,16 +179,10 @@ func (rb *Bitmap) arrAndNotRun(c1, c2 *container) bool {
 	a, runs := c1.Data, c2.Data
 	out := a[:0]
 
+	// Both are sorted, so the run cursor only moves forward
+	j, inRun := 0, false
 	for _, val := range a {
-		// Check if value is in any run
-		inRun := false
-		for i := 0; i < len(runs); i += 2 {
-			if val >= runs[i] && val <= runs[i+1] {
-				inRun = true
-				break
-			}
-		}
-		if !inRun {
+		if j, inRun = runSeek(runs, j, val); !inRun {
 			out = append(out, val)
 		}
 	}
@@ -145,12 +195,31 @@ func (rb *Bitmap) arrAndNotRun(c1, c2 *container) bool {
 // bmpAndNotArr performs AND NOT between bitmap and array containers
 func (rb *Bitmap) bmpAndNotArr(c1, c2 *container) bool {
 	bmp := c1.bmp()
-	for _, val := range c2.Data {
-		if bmp.Contains(uint32(val)) {
-			bmp.Remove(uint32(val))
-			c1.Size--
+	if len(c2.Data) <= clearMaxSize {
+		removed := uint32(0)
+		for _, val := range c2.Data {
+			if bmp.Contains(uint32(val)) {
+				bmp.Remove(uint32(val))
+				removed++
+			}
+		}
+
+		// A stale size smaller than the removals would underflow, recount instead
+		switch {
+		case removed > c1.Size:
+			c1.Size = uint32(bmp.Count())
+		default:
+			c1.Size -= removed
 		}
+		return c1.Size > 0
+	}
+
+	for _, val := range c2.Data {
+		bmp.Remove(uint32(val))
 	}
+
+	// Recount rather than decrement, cheaper than tracking many removals
+	c1.Size = uint32(bmp.Count())
 	return c1.Size > 0
 }
 
@@ -161,8 +230,7 @@ func (rb *Bitmap) bmpAndNotBmp(c1, c2 *container) bool {
 		return c1.Size > 0
 	}
 
-	a.AndNot(b)
-	c1.Size = uint32(a.Count())
+	c1.Size = uint32(bmpAndNotCount(a, b))
 	return c1.Size > 0
 }
 
@@ -171,15 +239,31 @@ func (rb *Bitmap) bmpAndNotRun(c1, c2 *container) bool {
 	bmp := c1.bmp()
 	runs := c2.Data
 
-	for i := 0; i < len(runs); i += 2 {
-		start, end := uint32(runs[i]), uint32(runs[i+1])
-		for v := start; v <= end; v++ {
-			if bmp.Contains(v) {
-				bmp.Remove(v)
-				c1.Size--
-			}
+	// Few values to remove, count the bits cleared by each run and subtract them
+	if c2.Size <= clearMaxSize {
+		removed := uint32(0)
+		for i := 0; i < len(runs); i += 2 {
+			start, end := uint32(runs[i]), uint32(runs[i+1])
+			removed += uint32(bmpCountRange(bmp, start, end))
+			bmpClearRange(bmp, start, end)
+		}
+
+		// A stale size smaller than the removals would underflow, recount instead
+		switch {
+		case removed > c1.Size:
+			c1.Size = uint32(bmp.Count())
+		default:
+			c1.Size -= removed
 		}
+		return c1.Size > 0
 	}
+
+	// Many values to remove, mask out entire words and recount
+	for i := 0; i < len(runs); i += 2 {
+		bmpClearRange(bmp, uint32(runs[i]), uint32(runs[i+1]))
+	}
+
+	c1.Size = uint32(bmp.Count())
 	return c1.Size > 0
 }
 
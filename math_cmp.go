@@ -0,0 +1,324 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"math/bits"
+	"slices"
+
+	"github.com/kelindar/bitmap"
+)
+
+// ContainsAllOf checks whether every value of the other bitmap is also present in
+// this bitmap, in other words whether the other bitmap is a subset of this one.
+func (rb *Bitmap) ContainsAllOf(other *Bitmap) bool {
+	return other.isSubset(rb)
+}
+
+// ContainsRange checks whether every value in the half-open interval [lo, hi) is present
+// in the bitmap, which is true for an empty interval. Each container spanned by the
+// interval must exist and fully cover its part, so the check stops at the first gap.
+func (rb *Bitmap) ContainsRange(lo, hi uint32) bool {
+	if lo >= hi {
+		return true
+	}
+
+	hi-- // closed interval from here on
+	k0, k1 := uint16(lo>>16), uint16(hi>>16)
+	i, _ := find16(rb.index, k0)
+	if len(rb.index)-i <= int(k1-k0) {
+		return false // not enough containers left to span the keys
+	}
+
+	for key := k0; ; key++ {
+		start, end := uint16(0), uint16(0xFFFF)
+		if key == k0 {
+			start = uint16(lo & 0xFFFF)
+		}
+		if key == k1 {
+			end = uint16(hi & 0xFFFF)
+		}
+
+		if rb.index[i] != key || !rb.containers[i].containsRange(start, end) {
+			return false
+		}
+
+		if key == k1 {
+			return true
+		}
+		i++
+	}
+}
+
+// IsSubset checks whether every value of this bitmap is also present in the other
+// bitmap. An empty bitmap is a subset of any bitmap, including an empty one.
+func (rb *Bitmap) IsSubset(other *Bitmap) bool {
+	return rb.isSubset(other)
+}
+
+// IsSuperset checks whether every value of the other bitmap is also present in this
+// bitmap. It mirrors IsSubset and is the same check as ContainsAllOf.
+func (rb *Bitmap) IsSuperset(other *Bitmap) bool {
+	return other.isSubset(rb)
+}
+
+// BucketContains checks whether the container with the given key contains every value
+// of the given dense bucket, where each bit of the bucket is a low 16-bit value.
+func (rb *Bitmap) BucketContains(key uint16, bm bitmap.Bitmap) bool {
+	idx, exists := find16(rb.index, key)
+	if !exists {
+		_, ok := bm.Min()
+		return !ok
+	}
+
+	c := &rb.containers[idx]
+	if c.Type == typeBitmap {
+		dst := c.bmp()
+		for i, w := range bm {
+			if w != 0 && (i >= len(dst) || w&^dst[i] != 0) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i, w := range bm {
+		for ; w != 0; w &= w - 1 {
+			x := i<<6 + bits.TrailingZeros64(w)
+			if x > 0xFFFF || !c.contains(uint16(x)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Equals checks whether both bitmaps contain exactly the same values. Containers are
+// compared by membership rather than by their data, so the same set of values stored
+// as an array, a bitmap or runs is considered equal.
+func (rb *Bitmap) Equals(other *Bitmap) bool {
+	switch {
+	case other == nil:
+		return rb.Count() == 0
+	case rb.Count() != other.Count() || !slices.Equal(rb.index, other.index):
+		return false
+	}
+
+	for i := range rb.containers {
+		if !ctrEquals(&rb.containers[i], &other.containers[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ctrEquals checks whether both containers hold exactly the same values
+func ctrEquals(c1, c2 *container) bool {
+	switch {
+	case c1.Size != c2.Size:
+		return false
+	case c1.Type == typeArray && c2.Type == typeArray:
+		return slices.Equal(c1.Data, c2.Data)
+	case c2.Type == typeArray:
+		c1, c2 = c2, c1 // probing the values of an array is the cheapest
+	}
+
+	// Of equal size, so one being a subset of the other means they are equal
+	return ctrSubset(c1, c2)
+}
+
+// Intersects checks whether both bitmaps share at least one value. It stops at the first
+// shared value found, without counting or building their intersection.
+func (rb *Bitmap) Intersects(other *Bitmap) bool {
+	if other == nil || len(rb.containers) == 0 || len(other.containers) == 0 {
+		return false
+	}
+
+	// Iterate over the smaller index, searching the larger one with a narrowing window
+	a, b := rb, other
+	if len(a.index) > len(b.index) {
+		a, b = b, a
+	}
+
+	for i, pos := 0, 0; i < len(a.index) && pos < len(b.index); i++ {
+		idx, exists := find16(b.index[pos:], a.index[i])
+		if pos += idx; exists && ctrIntersects(&a.containers[i], &b.containers[pos]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Disjoint checks whether both bitmaps have no value in common, it is the negation of
+// Intersects and stops at the first shared value found.
+func (rb *Bitmap) Disjoint(other *Bitmap) bool {
+	return !rb.Intersects(other)
+}
+
+// ctrIntersects checks whether both containers share at least one value
+func ctrIntersects(c1, c2 *container) bool {
+	if c1.Type > c2.Type {
+		c1, c2 = c2, c1 // ordered as array, bitmap then run
+	}
+
+	switch c1.Type {
+	case typeArray:
+		switch c2.Type {
+		case typeArray:
+			a, b := c1.Data, c2.Data
+			for i, j := 0, 0; i < len(a) && j < len(b); {
+				switch {
+				case a[i] < b[j]:
+					i++
+				case a[i] > b[j]:
+					j++
+				default:
+					return true
+				}
+			}
+		case typeBitmap:
+			b := c2.bmp()
+			for _, v := range c1.Data {
+				if b.Contains(uint32(v)) {
+					return true
+				}
+			}
+		case typeRun:
+			j, ok := 0, false
+			for _, v := range c1.Data {
+				if j, ok = runSeek(c2.Data, j, v); ok {
+					return true
+				}
+			}
+		}
+	case typeBitmap:
+		a := c1.bmp()
+		switch c2.Type {
+		case typeBitmap:
+			b := c2.bmp()
+			for i := range a {
+				if a[i]&b[i] != 0 {
+					return true
+				}
+			}
+		case typeRun:
+			for i := 0; i+1 < len(c2.Data); i += 2 {
+				if bmpCountRange(a, uint32(c2.Data[i]), uint32(c2.Data[i+1])) > 0 {
+					return true
+				}
+			}
+		}
+	case typeRun:
+		a, b := c1.Data, c2.Data
+		for i, j := 0, 0; i+1 < len(a) && j+1 < len(b); {
+			if max(a[i], b[j]) <= min(a[i+1], b[j+1]) {
+				return true
+			}
+
+			// Advance the run which ends first
+			if a[i+1] < b[j+1] {
+				i += 2
+			} else {
+				j += 2
+			}
+		}
+	}
+	return false
+}
+
+// isSubset checks whether every value of this bitmap is also present in the other
+func (rb *Bitmap) isSubset(other *Bitmap) bool {
+	switch {
+	case rb == nil || len(rb.containers) == 0:
+		return true
+	case other == nil || len(other.containers) < len(rb.containers):
+		return false
+	}
+
+	for i, pos := 0, 0; i < len(rb.containers); i++ {
+		idx, exists := find16(other.index[pos:], rb.index[i])
+		if pos += idx; !exists || !ctrSubset(&rb.containers[i], &other.containers[pos]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ctrSubset checks whether every value of the first container is present in the second
+func ctrSubset(c1, c2 *container) bool {
+	if c1.Size > c2.Size {
+		return false
+	}
+
+	switch c1.Type {
+	case typeArray:
+		if c2.Type == typeRun {
+			j, ok := 0, false
+			for _, v := range c1.Data {
+				if j, ok = runSeek(c2.Data, j, v); !ok {
+					return false
+				}
+			}
+			return true
+		}
+
+		for _, v := range c1.Data {
+			if !c2.contains(v) {
+				return false
+			}
+		}
+		return true
+	case typeBitmap:
+		if c2.Type == typeBitmap {
+			a, b := c1.bmp(), c2.bmp()
+			for i := range a {
+				if a[i]&^b[i] != 0 {
+					return false
+				}
+			}
+			return true
+		}
+
+		return c1.bmpRange(func(x uint32) bool {
+			return c2.contains(uint16(x))
+		})
+	case typeRun:
+		for i := 0; i+1 < len(c1.Data); i += 2 {
+			if !c2.containsRange(c1.Data[i], c1.Data[i+1]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// containsRange checks whether the container contains every value in [start, end]
+func (c *container) containsRange(start, end uint16) bool {
+	switch c.Type {
+	case typeArray:
+		// Arrays are strictly sorted, so the range is present if it is contiguous
+		i, ok := find16(c.Data, start)
+		j := i + int(end-start)
+		return ok && j < len(c.Data) && c.Data[j] == end
+	case typeBitmap:
+		return bmpFullRange(c.bmp(), uint32(start), uint32(end))
+	case typeRun:
+		idx, ok := c.runFind(start)
+		if !ok {
+			return false
+		}
+
+		// Adjacent runs are not necessarily merged, so keep walking while they touch
+		i := idx[0] * 2
+		for c.Data[i+1] < end {
+			if i+2 >= len(c.Data) || c.Data[i+2] != c.Data[i+1]+1 {
+				return false
+			}
+			i += 2
+		}
+		return true
+	}
+	return false
+}
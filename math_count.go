@@ -0,0 +1,284 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"math/bits"
+
+	"github.com/kelindar/bitmap"
+)
+
+// Compare returns the sizes of the partition formed by both bitmaps: the number of values
+// only present in this bitmap, present in both and only present in the other. It walks
+// both bitmaps once without modifying or cloning either of them.
+func (rb *Bitmap) Compare(other *Bitmap) (aOnly, both, bOnly int) {
+	if other == nil {
+		return rb.Count(), 0, 0
+	}
+
+	both = rb.andCount(other)
+	return rb.Count() - both, both, other.Count() - both
+}
+
+// AndCardinality returns the number of values present in both bitmaps, without building
+// their intersection or modifying either of them.
+func (rb *Bitmap) AndCardinality(other *Bitmap) int {
+	if rb == nil || other == nil || len(rb.containers) == 0 || len(other.containers) == 0 {
+		return 0
+	}
+
+	return rb.andCount(other)
+}
+
+// OrCardinality returns the number of values present in either bitmap, without building
+// their union or modifying either of them. Only the containers with keys present in both
+// are intersected, the sizes of all other containers are simply summed.
+func (rb *Bitmap) OrCardinality(other *Bitmap) int {
+	if other == nil {
+		return rb.Count()
+	}
+
+	return rb.Count() + other.Count() - rb.AndCardinality(other)
+}
+
+// XorCardinality returns the number of values present in exactly one of the bitmaps,
+// without building their symmetric difference or modifying either of them.
+func (rb *Bitmap) XorCardinality(other *Bitmap) int {
+	if other == nil {
+		return rb.Count()
+	}
+
+	return rb.Count() + other.Count() - 2*rb.AndCardinality(other)
+}
+
+// AndNotCardinality returns the number of values present in this bitmap but not in the
+// other, without building their difference or modifying either of them.
+func (rb *Bitmap) AndNotCardinality(other *Bitmap) int {
+	return rb.Count() - rb.AndCardinality(other)
+}
+
+// JaccardMatrix returns the symmetric matrix of pairwise Jaccard similarities between
+// the bitmaps, where two empty bitmaps are considered identical. The count of each bitmap
+// is computed once and only the upper triangle is intersected, deriving the union size
+// from |A| + |B| - |A ∩ B|, yet the cost remains O(N²) intersections for N bitmaps.
+func JaccardMatrix(bitmaps []*Bitmap) [][]float64 {
+	counts := make([]int, len(bitmaps))
+	for i, bm := range bitmaps {
+		counts[i] = bm.Count()
+	}
+
+	out := make([][]float64, len(bitmaps))
+	for i := range out {
+		out[i] = make([]float64, len(bitmaps))
+		out[i][i] = 1
+	}
+
+	for i := range bitmaps {
+		for j := i + 1; j < len(bitmaps); j++ {
+			both := bitmaps[i].AndCardinality(bitmaps[j])
+			score := 1.0
+			if union := counts[i] + counts[j] - both; union > 0 {
+				score = float64(both) / float64(union)
+			}
+
+			out[i][j], out[j][i] = score, score
+		}
+	}
+	return out
+}
+
+// CountRange returns the number of values in the half-open interval [start, end)
+func (rb *Bitmap) CountRange(start, end uint32) int {
+	if start >= end {
+		return 0
+	}
+
+	return int(rb.countRange(start, end-1))
+}
+
+// Rank returns the number of values in the bitmap which are smaller than or equal to x
+func (rb *Bitmap) Rank(x uint32) int {
+	return int(rb.countRange(0, x))
+}
+
+// AbsentCountInRange returns the number of values in the half-open interval [start, end)
+// which are not present in the bitmap.
+func (rb *Bitmap) AbsentCountInRange(start, end uint32) int {
+	return int(rb.AbsentCountInRange64(uint64(start), uint64(end)))
+}
+
+// AbsentCountInRange64 returns the number of values in the half-open interval [start, end)
+// which are not present in the bitmap. The end may be up to 1<<32, so that the entire
+// universe of 32-bit values can be covered.
+func (rb *Bitmap) AbsentCountInRange64(start, end uint64) uint64 {
+	end = min(end, 1<<32)
+	if start >= end {
+		return 0
+	}
+
+	return (end - start) - rb.countRange(uint32(start), uint32(end-1))
+}
+
+// countRange returns the number of values in the closed interval [lo, hi]
+func (rb *Bitmap) countRange(lo, hi uint32) uint64 {
+	k0, k1 := uint16(lo>>16), uint16(hi>>16)
+	count := uint64(0)
+	for i, _ := find16(rb.index, k0); i < len(rb.index) && rb.index[i] <= k1; i++ {
+		key := rb.index[i]
+		start, end := uint16(0), uint16(0xFFFF)
+		if key == k0 {
+			start = uint16(lo & 0xFFFF)
+		}
+		if key == k1 {
+			end = uint16(hi & 0xFFFF)
+		}
+
+		count += uint64(rb.containers[i].countRange(start, end))
+	}
+	return count
+}
+
+// countRange returns the number of values of the container in [start, end]
+func (c *container) countRange(start, end uint16) int {
+	if start == 0 && end == 0xFFFF {
+		return int(c.Size)
+	}
+
+	switch c.Type {
+	case typeArray:
+		i, _ := find16(c.Data, start)
+		j, found := find16(c.Data, end)
+		if found {
+			j++
+		}
+		return j - i
+	case typeBitmap:
+		return bmpCountRange(c.bmp(), uint32(start), uint32(end))
+	case typeRun:
+		return runAndCountRun(c.Data, []uint16{start, end})
+	}
+	return 0
+}
+
+// andCount returns the number of values present in both bitmaps
+func (rb *Bitmap) andCount(other *Bitmap) int {
+	count := 0
+	for i, j := 0, 0; i < len(rb.index) && j < len(other.index); {
+		switch hi1, hi2 := rb.index[i], other.index[j]; {
+		case hi1 < hi2:
+			i++
+		case hi1 > hi2:
+			j++
+		default:
+			count += ctrAndCount(&rb.containers[i], &other.containers[j])
+			i++
+			j++
+		}
+	}
+	return count
+}
+
+// ctrAndCount returns the number of values present in both containers
+func ctrAndCount(c1, c2 *container) int {
+	switch c1.Type {
+	case typeArray:
+		switch c2.Type {
+		case typeArray:
+			return arrAndCountArr(c1.Data, c2.Data)
+		default:
+			return arrAndCount(c1.Data, c2)
+		}
+	case typeBitmap:
+		switch c2.Type {
+		case typeArray:
+			return arrAndCount(c2.Data, c1)
+		case typeBitmap:
+			a, b := c1.bmp(), c2.bmp()
+			count := 0
+			for i := range a {
+				count += bits.OnesCount64(a[i] & b[i])
+			}
+			return count
+		case typeRun:
+			return bmpAndCountRun(c1.bmp(), c2.Data)
+		}
+	case typeRun:
+		switch c2.Type {
+		case typeArray:
+			return arrAndCount(c2.Data, c1)
+		case typeBitmap:
+			return bmpAndCountRun(c2.bmp(), c1.Data)
+		case typeRun:
+			return runAndCountRun(c1.Data, c2.Data)
+		}
+	}
+	return 0
+}
+
+// arrAndCountArr counts the values present in both sorted arrays
+func arrAndCountArr(a, b []uint16) int {
+	count, i, j := 0, 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			count++
+			i++
+			j++
+		}
+	}
+	return count
+}
+
+// arrAndCount counts the values of the array present in the container
+func arrAndCount(a []uint16, c *container) int {
+	count := 0
+	switch c.Type {
+	case typeRun:
+		j, ok := 0, false
+		for _, v := range a {
+			if j, ok = runSeek(c.Data, j, v); ok {
+				count++
+			}
+		}
+	default:
+		for _, v := range a {
+			if c.contains(v) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// bmpAndCountRun counts the bits of the bitmap set within the runs
+func bmpAndCountRun(b bitmap.Bitmap, runs []uint16) int {
+	count := 0
+	for i := 0; i+1 < len(runs); i += 2 {
+		count += bmpCountRange(b, uint32(runs[i]), uint32(runs[i+1]))
+	}
+	return count
+}
+
+// runAndCountRun counts the values covered by both sets of runs
+func runAndCountRun(a, b []uint16) int {
+	count, i, j := 0, 0, 0
+	for i < len(a) && j < len(b) {
+		lo, hi := max(a[i], b[j]), min(a[i+1], b[j+1])
+		if lo <= hi {
+			count += int(hi-lo) + 1
+		}
+
+		// Advance the run which ends first
+		if a[i+1] < b[j+1] {
+			i += 2
+		} else {
+			j += 2
+		}
+	}
+	return count
+}
@@ -3,76 +3,171 @@
 
 package roaring
 
-// or performs OR with a single bitmap efficiently
-func (rb *Bitmap) or(other *Bitmap) {
+import "slices"
+
+// or performs OR with a single bitmap efficiently, optionally collecting the stats
+func (rb *Bitmap) or(other *Bitmap, stats *MergeStats) {
 	switch {
 	case other == nil || len(other.containers) == 0:
 		return // No change needed
 	case len(rb.containers) == 0:
-		// Copy all containers from other
-		rb.containers = make([]container, len(other.containers))
-		rb.index = make([]uint16, len(other.index))
+		// Copy all containers from other, reusing the capacity we already have
+		if cap(rb.containers) < len(other.containers) {
+			rb.containers = make([]container, len(other.containers))
+		}
+		if cap(rb.index) < len(other.index) {
+			rb.index = make([]uint16, len(other.index))
+		}
+
+		rb.containers = rb.containers[:len(other.containers)]
+		rb.index = rb.index[:len(other.index)]
 		for i := range other.containers {
-			other.containers[i].Shared = true
+			rb.containers[i] = other.containers[i].share()
 		}
-		copy(rb.containers, other.containers)
 		copy(rb.index, other.index)
+		stats.collect(rb, len(other.containers))
 		return
 	}
 
-	// Merge containers from both bitmaps
-	i, j := 0, 0
-	var newContainers []container
-	var newIndex []uint16
-
-	for i < len(rb.containers) && j < len(other.containers) {
-		hi1, hi2 := rb.index[i], other.index[j]
+	// Count the keys which are only present in the other bitmap
+	created := 0
+	for i, j := 0, 0; j < len(other.index); {
 		switch {
-		case hi1 < hi2:
-			// Only in left bitmap
-			newContainers = append(newContainers, rb.containers[i])
-			newIndex = append(newIndex, hi1)
+		case i < len(rb.index) && rb.index[i] < other.index[j]:
+			i++
+		case i < len(rb.index) && rb.index[i] == other.index[j]:
 			i++
-		case hi1 > hi2:
-			// Only in right bitmap
-			other.containers[j].Shared = true
-			newContainers = append(newContainers, other.containers[j])
-			newIndex = append(newIndex, hi2)
 			j++
 		default:
-			// In both bitmaps - merge them
-			c1 := &rb.containers[i]
-			c2 := &other.containers[j]
-			rb.ctrOr(c1, c2)
-			newContainers = append(newContainers, *c1)
-			newIndex = append(newIndex, hi1)
-			i++
+			created++
 			j++
 		}
 	}
 
-	// Add remaining containers from left
-	for i < len(rb.containers) {
-		newContainers = append(newContainers, rb.containers[i])
-		newIndex = append(newIndex, rb.index[i])
-		i++
+	// Grow the storage and merge from the back, so that the containers of this bitmap
+	// are moved at most once and merged in place when there are no new keys.
+	n := len(rb.containers)
+	rb.containers = slices.Grow(rb.containers, created)[:n+created]
+	rb.index = slices.Grow(rb.index, created)[:n+created]
+	for i, j, k := n-1, len(other.index)-1, n+created-1; j >= 0; k-- {
+		switch {
+		case i >= 0 && rb.index[i] > other.index[j]:
+			// Only in left bitmap
+			rb.containers[k], rb.index[k] = rb.containers[i], rb.index[i]
+			i--
+		case i >= 0 && rb.index[i] == other.index[j]:
+			// In both bitmaps - merge them, reading other without sharing its data
+			c1 := &rb.containers[i]
+			typ, size := c1.Type, c1.Size
+			rb.ctrOr(c1, &other.containers[j])
+			stats.merged(typ, size, c1)
+			rb.containers[k], rb.index[k] = rb.containers[i], rb.index[i]
+			i--
+			j--
+		default:
+			// Only in right bitmap - alias it, the only case where other loses sole ownership
+			rb.containers[k], rb.index[k] = other.containers[j].share(), other.index[j]
+			j--
+		}
 	}
 
-	// Add remaining containers from right
-	for j < len(other.containers) {
-		other.containers[j].Shared = true
-		newContainers = append(newContainers, other.containers[j])
-		newIndex = append(newIndex, other.index[j])
-		j++
+	stats.collect(rb, created)
+}
+
+// OrValues performs bitwise OR operation with a slice of values, ideally sorted in
+// ascending order. Values sharing the same high bits are merged into their container at
+// once. The slice is left untouched, and is copied to be sorted if it is not sorted already.
+func (rb *Bitmap) OrValues(values []uint32) {
+	if !slices.IsSorted(values) {
+		values = slices.Clone(values)
+		slices.Sort(values)
+	}
+
+	lows := borrowArray()
+	for i, pos := 0, 0; i < len(values); {
+		hi := uint16(values[i] >> 16)
+
+		// Collect the low bits of this group, skipping duplicates
+		lows = lows[:0]
+		for ; i < len(values) && uint16(values[i]>>16) == hi; i++ {
+			if n, lo := len(lows), uint16(values[i]&0xFFFF); n == 0 || lows[n-1] != lo {
+				lows = append(lows, lo)
+			}
+		}
+
+		pos = rb.ctrOrValues(hi, pos, lows)
+	}
+	release(lows)
+}
+
+// OrRuns performs bitwise OR operation with a batch of closed intervals [lo, hi]. The
+// intervals are sorted and coalesced first, so that overlapping or adjacent intervals
+// are only added once. Inverted intervals where lo > hi are ignored.
+func (rb *Bitmap) OrRuns(runs [][2]uint32) {
+	if len(runs) == 0 {
+		return
+	}
+
+	// Pack the intervals so they sort by their start, then by their end
+	sorted := make([]uint64, 0, len(runs))
+	for _, r := range runs {
+		if r[0] <= r[1] {
+			sorted = append(sorted, uint64(r[0])<<32|uint64(r[1]))
+		}
+	}
+	slices.Sort(sorted)
+
+	lo, hi, ok := uint32(0), uint32(0), false
+	for _, r := range sorted {
+		start, end := uint32(r>>32), uint32(r)
+		switch {
+		case ok && uint64(start) <= uint64(hi)+1:
+			hi = max(hi, end)
+		default:
+			if ok {
+				rb.addRange(lo, hi)
+			}
+			lo, hi, ok = start, end, true
+		}
 	}
 
-	rb.containers = newContainers
-	rb.index = newIndex
+	if ok {
+		rb.addRange(lo, hi)
+	}
+}
+
+// ctrOrValues merges sorted low bits into the container with the given key, searching
+// the index from the given position onwards. It returns the position of the container.
+func (rb *Bitmap) ctrOrValues(hi uint16, pos int, lows []uint16) int {
+	idx, exists := find16(rb.index[pos:], hi)
+	idx += pos
+
+	switch {
+	case !exists:
+		rb.ctrAdd(hi, idx, &container{
+			Type: typeArray,
+			Size: uint32(len(lows)),
+			Data: append(make([]uint16, 0, len(lows)), lows...),
+		})
+	default:
+		rb.ctrOr(&rb.containers[idx], &container{
+			Type: typeArray,
+			Size: uint32(len(lows)),
+			Data: lows,
+		})
+	}
+
+	// Large or densely filled arrays need a better representation
+	if c := &rb.containers[idx]; c.Type == typeArray && (c.Size > arrMinSize || !exists && c.arrIsDense(rb.opts)) {
+		c.optimize(rb.opts)
+	}
+	return idx
 }
 
 // ctrOr performs efficient OR between two containers
 func (rb *Bitmap) ctrOr(c1, c2 *container) {
 	c1.fork()
+	defer c1.repin(c1.Type)
 	switch c1.Type {
 	case typeArray:
 		switch c2.Type {
@@ -193,11 +288,11 @@ func (rb *Bitmap) arrOrRun(c1, c2 *container) {
 func (rb *Bitmap) bmpOrArr(c1, c2 *container) {
 	bmp := c1.bmp()
 	for _, val := range c2.Data {
-		if !bmp.Contains(uint32(val)) {
-			bmp.Set(uint32(val))
-			c1.Size++
-		}
+		bmp.Set(uint32(val))
 	}
+
+	// Recount rather than increment, so that a stale size cannot compound
+	c1.Size = uint32(bmp.Count())
 }
 
 // bmpOrBmp performs OR between two bitmap containers
@@ -207,8 +302,7 @@ func (rb *Bitmap) bmpOrBmp(c1, c2 *container) {
 		return
 	}
 
-	a.Or(b)
-	c1.Size = uint32(a.Count())
+	c1.Size = uint32(bmpOrCount(a, b))
 }
 
 // bmpOrRun performs OR between bitmap and run containers
@@ -219,19 +313,19 @@ func (rb *Bitmap) bmpOrRun(c1, c2 *container) {
 	for i := 0; i < len(runs); i += 2 {
 		start, end := uint32(runs[i]), uint32(runs[i+1])
 		for v := start; v <= end; v++ {
-			if !bmp.Contains(v) {
-				bmp.Set(v)
-				c1.Size++
-			}
+			bmp.Set(v)
 		}
 	}
+
+	// Recount rather than increment, so that a stale size cannot compound
+	c1.Size = uint32(bmp.Count())
 }
 
 // runOrArr performs OR between run and array containers
 func (rb *Bitmap) runOrArr(c1, c2 *container) {
 	c1.runToArray()
 	rb.arrOrArr(c1, c2)
-	c1.optimize()
+	c1.optimize(rb.opts)
 }
 
 // runOrBmp performs OR between run and bitmap containers
@@ -262,29 +356,18 @@ func (rb *Bitmap) runOrRun(c1, c2 *container) {
 
 		// Check if runs overlap or are adjacent
 		if s1 <= e2+1 && s2 <= e1+1 {
-			// Merge runs - advance both and continue merging
-			switch {
-			case e1 < e2:
-				i += 2
-			case e2 < e1:
-				j += 2
-			default:
-				i += 2
-				j += 2
-			}
-
-			// Keep merging adjacent/overlapping runs
-			for i < len(a) && uint32(a[i]) <= ue+1 {
-				if uint32(a[i+1]) > ue {
-					ue = uint32(a[i+1])
-				}
-				i += 2
-			}
-			for j < len(b) && uint32(b[j]) <= ue+1 {
-				if uint32(b[j+1]) > ue {
-					ue = uint32(b[j+1])
+			// Keep merging overlapping or adjacent runs from either side, since extending
+			// the union with a run of one side may reach further runs of the other
+			for {
+				if i < len(a) && uint32(a[i]) <= ue+1 {
+					ue = max(ue, uint32(a[i+1]))
+					i += 2
+				} else if j < len(b) && uint32(b[j]) <= ue+1 {
+					ue = max(ue, uint32(b[j+1]))
+					j += 2
+				} else {
+					break
 				}
-				j += 2
 			}
 
 			out = append(out, uint16(us), uint16(ue))
@@ -4,9 +4,13 @@
 package roaring
 
 import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
 	"sort"
 	"testing"
 
+	"github.com/kelindar/bitmap"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -100,6 +104,40 @@ func TestAnd(t *testing.T) {
 	}
 }
 
+func TestAndOptimizesResult(t *testing.T) {
+	tc := []struct {
+		name   string
+		c1     *container
+		c2     *container
+		expect ctype
+	}{
+		{"bmp ∧ contiguous arr", newBmp(seq(0, 10000, 1)...), newArr(seq(1000, 3000, 1)...), typeRun},
+		{"bmp ∧ large arr", newBmp(seq(0, 10000, 1)...), newArr(seq(0, 6000, 2)...), typeBitmap},
+		{"bmp ∧ small arr", newBmp(seq(0, 10000, 1)...), newArr(seq(0, 6000, 20)...), typeArray},
+		{"run ∧ contiguous arr", newRun(seq(0, 10000, 1)...), newArr(seq(1000, 3000, 1)...), typeRun},
+		{"run ∧ large arr", newRun(seq(0, 10000, 1)...), newArr(seq(0, 6000, 2)...), typeBitmap},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			a, av := bitmapWith(tt.c1)
+			b, bv := bitmapWith(tt.c2)
+			a.And(b)
+
+			var expect []uint16
+			for _, v := range bv {
+				if slices.Contains(av, v) {
+					expect = append(expect, v)
+				}
+			}
+
+			assert.Equal(t, tt.expect, a.containers[0].Type)
+			assert.Equal(t, expect, valuesOf(a))
+			assert.NoError(t, a.Validate())
+		})
+	}
+}
+
 func TestAndNot(t *testing.T) {
 	tc := []struct {
 		name   string
@@ -214,6 +252,577 @@ func TestAndNot(t *testing.T) {
 	}
 }
 
+func TestEmptiedContainers(t *testing.T) {
+	build := func(first uint32) *Bitmap {
+		rb := New()
+		rb.ctrAdd(0, 0, newArr(first))
+		rb.ctrAdd(1, 1, newRun(0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10))
+		return rb
+	}
+
+	// The first container is emptied before the kernels of the second one run
+	and := build(5)
+	and.And(build(6))
+	assert.Equal(t, []uint16{1}, and.index)
+	assert.Equal(t, []uint16{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, valuesOf(and))
+
+	other := New()
+	other.ctrAdd(0, 0, newArr(6))
+	other.ctrAdd(1, 1, newArr(1, 2, 3))
+	and = build(5)
+	and.And(other)
+	assert.Equal(t, []uint16{1}, and.index)
+	assert.Equal(t, []uint16{1, 2, 3}, valuesOf(and))
+
+	other.containers[0] = *newArr(5)
+	andNot := build(5)
+	andNot.AndNot(other)
+	assert.Equal(t, []uint16{1}, andNot.index)
+	assert.Equal(t, []uint16{0, 4, 5, 6, 7, 8, 9, 10}, valuesOf(andNot))
+}
+
+func TestAndDisjoint(t *testing.T) {
+	lo, hi := New(), New()
+	lo.AddRange(0, 10<<16)
+	hi.AddRange(10<<16, 20<<16)
+
+	rb := lo.Clone(nil)
+	rb.And(hi)
+	assert.Equal(t, 0, rb.Count())
+
+	rb = hi.Clone(nil)
+	rb.And(lo)
+	assert.Equal(t, 0, rb.Count())
+
+	// Touching key ranges still intersect
+	hi.Set(9<<16 + 5)
+	rb = lo.Clone(nil)
+	rb.And(hi)
+	assert.Equal(t, []uint32{9<<16 + 5}, rb.ToArray())
+}
+
+func BenchmarkAndDisjoint(b *testing.B) {
+	for _, size := range []int{1e2, 1e4} {
+		b.Run(fmt.Sprintf("containers=%d", size), func(b *testing.B) {
+			base, other := New(), New()
+			for i := 0; i < size; i++ {
+				base.Set(uint32(i) << 16)
+				other.Set(uint32(i+size) << 16)
+			}
+
+			rb := New()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				base.Clone(rb)
+				b.StartTimer()
+				rb.And(other)
+			}
+		})
+	}
+}
+
+func TestAndNotBmpSize(t *testing.T) {
+	values := make([]uint32, 0, 3000)
+	for i := 0; i < 3000; i++ {
+		values = append(values, uint32(i*7))
+	}
+
+	t.Run("all present", func(t *testing.T) {
+		c := newBmp(values...)
+		var rb Bitmap
+		assert.True(t, rb.bmpAndNotArr(c, newArr(values[:1000]...)))
+		assert.Equal(t, uint32(2000), c.Size)
+		assert.Equal(t, c.cardinality(), c.Size)
+
+		assert.False(t, rb.bmpAndNotArr(c, newArr(values...)))
+		assert.Equal(t, uint32(0), c.Size)
+	})
+
+	t.Run("inconsistent size", func(t *testing.T) {
+		c := newBmp(values...)
+		c.Size = 10 // smaller than the actual cardinality
+
+		var rb Bitmap
+		assert.True(t, rb.bmpAndNotArr(c, newArr(values[:100]...)))
+		assert.Equal(t, uint32(2900), c.Size)
+	})
+
+	t.Run("inconsistent size with few removals", func(t *testing.T) {
+		c := newBmp(values...)
+		c.Size = 5 // smaller than the values removed below
+
+		var rb Bitmap
+		assert.True(t, rb.bmpAndNotArr(c, newArr(values[:10]...)))
+		assert.Equal(t, uint32(2990), c.Size)
+		assert.Equal(t, c.cardinality(), c.Size)
+
+		// The bitmap keeps its surviving values
+		rb.ctrAdd(0, 0, newBmp(values...))
+		rb.containers[0].Size = 5
+		rb.AndNot(bitmapOf(newArr(values[:10]...)))
+		assert.Equal(t, values[10:], rb.ToArray())
+		assert.NoError(t, rb.Validate())
+	})
+
+	t.Run("inconsistent size with runs", func(t *testing.T) {
+		c := newBmp(values...)
+		c.Size = 5 // smaller than the values removed by the runs
+
+		var rb Bitmap
+		assert.True(t, rb.bmpAndNotRun(c, newRun(seq(0, 60, 1)...)))
+		assert.Equal(t, uint32(2991), c.Size)
+		assert.Equal(t, c.cardinality(), c.Size)
+
+		assert.True(t, rb.bmpAndNotRun(c, newRun(seq(60, 70, 1)...)))
+		assert.Equal(t, uint32(2990), c.Size)
+	})
+}
+
+func TestAndNotBmpKernels(t *testing.T) {
+	base := seq(0, 65536, 3)
+	tc := []struct {
+		name string
+		c2   *container
+	}{
+		{"bmp ¬ tiny arr", newArr(seq(0, 100, 7)...)},
+		{"bmp ¬ huge arr", newArr(seq(0, 65536, 11)...)},
+		{"bmp ¬ tiny run", newRun(seq(60, 70, 1)...)},
+		{"bmp ¬ huge run", newRun(append(seq(63, 64000, 1), 65535)...)},
+		{"bmp ¬ word runs", newRun(append(append(seq(0, 64, 1), seq(128, 130, 1)...), seq(191, 320, 1)...)...)},
+		{"bmp ¬ split runs", newRun(seq(5, 65530, 2)...)},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			a, av := bitmapWith(newBmp(base...))
+			b, bv := bitmapWith(tt.c2)
+			a.AndNot(b)
+
+			expect := []uint16{}
+			for _, v := range av {
+				if !slices.Contains(bv, v) {
+					expect = append(expect, v)
+				}
+			}
+
+			assert.Equal(t, expect, valuesOf(a))
+			assert.Equal(t, len(expect), a.Count())
+		})
+	}
+}
+
+func TestAndNotRuns(t *testing.T) {
+	ctors := map[string]func(...uint32) *container{"arr": newArr, "bmp": newBmp, "run": newRun}
+	lefts := map[string][]uint32{
+		"sparse":  seq(0, 65536, 37),
+		"dense":   seq(1000, 3000, 1),
+		"striped": append(append(seq(0, 500, 1), seq(600, 900, 2)...), seq(65000, 65536, 1)...),
+	}
+
+	// Disjoint runs splitting the left operand into many pieces
+	rights := map[string][]uint32{
+		"edges":   append(seq(0, 3, 1), seq(65530, 65536, 1)...),
+		"singles": seq(1, 65536, 50),
+		"stripes": slices.Concat(seq(10, 20, 1), seq(100, 400, 1), seq(1500, 1510, 1), seq(2000, 2999, 1), seq(64990, 65100, 1)),
+		"gaps":    slices.Concat(seq(0, 999, 1), seq(1001, 2999, 1), seq(3001, 65535, 1)),
+	}
+
+	for n1, new1 := range ctors {
+		for n2, left := range lefts {
+			for n3, right := range rights {
+				t.Run(n1+" "+n2+" ¬ run "+n3, func(t *testing.T) {
+					c2 := newRun(right...)
+					assert.Greater(t, len(c2.Data)/2, 1)
+
+					removed := make(map[uint32]bool, len(right))
+					for _, v := range right {
+						removed[v] = true
+					}
+
+					expect := []uint16{}
+					for _, v := range left {
+						if !removed[v] {
+							expect = append(expect, uint16(v))
+						}
+					}
+
+					a := bitmapOf(new1(left...))
+					b, bv := bitmapWith(c2)
+					a.AndNot(b)
+					assert.Equal(t, expect, valuesOf(a))
+					assert.Equal(t, len(expect), a.Count())
+					assert.NoError(t, a.Validate())
+					assert.Equal(t, bv, valuesOf(b))
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkAndNotBmp(b *testing.B) {
+	var base, full []uint32
+	for v := 0; v < 65536; v++ {
+		full = append(full, uint32(v))
+		if v%3 == 0 {
+			base = append(base, uint32(v))
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		c2   *container
+	}{
+		{"tiny-arr", newArr(1, 2, 3, 100, 1000)},
+		{"tiny-run", newRun(100, 101, 102, 103)},
+		{"huge-run", newRun(full[:60000]...)},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			var rb Bitmap
+			c1 := newBmp(base...)
+			data, size := slices.Clone(c1.Data), c1.Size
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				copy(c1.Data, data)
+				c1.Size = size
+				rb.ctrAndNot(c1, tc.c2)
+			}
+		})
+	}
+}
+
+func TestAndNotEmpties(t *testing.T) {
+	superset := makeTestBitmap()
+	superset.AddRange(0, 3<<16)
+	for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+		rb, _ := changeType(typ)
+		rb.Or(makeTestBitmap())
+		assert.False(t, rb.IsEmpty())
+
+		rb.AndNot(superset)
+		assert.True(t, rb.IsEmpty())
+		assert.Equal(t, 0, len(rb.containers))
+		assert.Equal(t, 0, rb.Count())
+	}
+
+	// Containers emptied in the middle are removed as well
+	rb := makeTestBitmap()
+	rb.AndNot(FromArray(seq(65535, 2*65536, 1)))
+	assert.Equal(t, []uint16{0, 2, 65535}, rb.index)
+	assert.True(t, New().IsEmpty())
+}
+
+func TestRemoveAll(t *testing.T) {
+	a, b := New(), New()
+	for i := uint32(0); i < 200000; i += 3 {
+		a.Set(i)
+	}
+	for i := uint32(0); i < 300000; i += 2 {
+		b.Set(i)
+	}
+
+	expect := a.Clone(nil)
+	expect.AndNot(b)
+	a.RemoveAll(b)
+	bitmapsEqual(t, expect, a)
+	assert.False(t, a.Contains(6))
+	assert.True(t, a.Contains(3))
+}
+
+func BenchmarkRemoveAll(b *testing.B) {
+	for _, size := range []int{1e3, 1e4, 1e5} {
+		b.Run(fmt.Sprintf("other=%d", size), func(b *testing.B) {
+			rb, other := New(), New()
+			for _, v := range []uint32{2, 1 << 20, 1 << 30} {
+				rb.Set(v)
+			}
+
+			// Odd values only, so that nothing is removed across iterations
+			for i := 0; i < size; i++ {
+				other.Set(uint32(i*(1<<32/size)) + 1)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rb.RemoveAll(other)
+			}
+		})
+	}
+}
+
+func TestArrRunKernels(t *testing.T) {
+	arr := seq(0, 65536, 3)
+	runs := append(seq(100, 200, 1), append(seq(1000, 5000, 1), seq(60000, 65536, 1)...)...)
+	a, av := bitmapWith(newArr(arr...))
+	b, bv := bitmapWith(newRun(runs...))
+
+	var and, andNot, xor []uint16
+	for _, v := range av {
+		if slices.Contains(bv, v) {
+			and = append(and, v)
+		} else {
+			andNot = append(andNot, v)
+		}
+	}
+	for v := 0; v < 65536; v++ {
+		if slices.Contains(av, uint16(v)) != slices.Contains(bv, uint16(v)) {
+			xor = append(xor, uint16(v))
+		}
+	}
+
+	assert.Equal(t, len(and), ctrAndCount(&a.containers[0], &b.containers[0]))
+	assert.Equal(t, len(and), ctrAndCount(&b.containers[0], &a.containers[0]))
+	assert.False(t, ctrSubset(&a.containers[0], &b.containers[0]))
+	assert.True(t, ctrSubset(newArr(100, 150, 4000, 65535), &b.containers[0]))
+
+	for name, op := range map[string]struct {
+		fn     func(a, b *Bitmap)
+		expect []uint16
+	}{
+		"and":    {func(a, b *Bitmap) { a.And(b) }, and},
+		"andnot": {func(a, b *Bitmap) { a.AndNot(b) }, andNot},
+		"xor":    {func(a, b *Bitmap) { a.Xor(b) }, xor},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rb := a.Clone(nil)
+			op.fn(rb, b)
+			assert.Equal(t, op.expect, valuesOf(rb))
+			assert.NoError(t, rb.Validate())
+		})
+	}
+}
+
+func BenchmarkArrRun(b *testing.B) {
+	arr := newArr(seq(0, 65536, 2)...)
+	run := newRun(seq(0, 65536, 3)...)
+	data := slices.Clone(arr.Data)
+	ops := map[string]func(rb *Bitmap, c1, c2 *container){
+		"and":    func(rb *Bitmap, c1, c2 *container) { rb.ctrAnd(c1, c2) },
+		"andnot": func(rb *Bitmap, c1, c2 *container) { rb.ctrAndNot(c1, c2) },
+		"xor":    func(rb *Bitmap, c1, c2 *container) { rb.ctrXor(c1, c2) },
+		"count":  func(rb *Bitmap, c1, c2 *container) { ctrAndCount(c1, c2) },
+	}
+
+	for _, name := range []string{"and", "andnot", "xor", "count"} {
+		b.Run(name, func(b *testing.B) {
+			var rb Bitmap
+			c1 := &container{Type: typeArray}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c1.Type, c1.Data, c1.Size = typeArray, append(c1.Data[:0], data...), uint32(len(data))
+				ops[name](&rb, c1, run)
+			}
+		})
+	}
+}
+
+func TestOrCopyOnWrite(t *testing.T) {
+	base := New()
+	for key := uint32(0); key < 1000; key++ {
+		base.AddRange(key<<16, key<<16+100)
+		base.Set(key<<16 + 5000)
+	}
+
+	delta := FromArray([]uint32{3<<16 + 200, 500<<16 + 300, 999<<16 + 1, 2000 << 16})
+	clone := base.Clone(nil)
+	clone.Or(delta)
+
+	// Only the touched containers were forked, the rest is still shared
+	forked := 0
+	for i := range clone.containers {
+		if !clone.containers[i].Shared {
+			forked++
+		}
+	}
+	assert.Equal(t, 3, forked)
+	assert.Equal(t, 1001, len(clone.containers))
+	assert.Equal(t, base.Count()+3, clone.Count())
+	assert.False(t, base.Contains(3<<16+200))
+	assert.True(t, clone.Contains(2000<<16))
+}
+
+func BenchmarkOrCloneDelta(b *testing.B) {
+	base := New()
+	for key := uint32(0); key < 10000; key++ {
+		base.AddRange(key<<16, key<<16+100)
+		base.Set(key<<16 + 5000)
+	}
+
+	delta := FromArray([]uint32{3<<16 + 200, 500<<16 + 300, 9999<<16 + 1})
+	clone := New()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		base.Clone(clone)
+		clone.Or(delta)
+	}
+}
+
+func TestOrRepairsSize(t *testing.T) {
+	for name, c2 := range map[string]*container{
+		"arr": newArr(1, 2, 3, 5000),
+		"run": newRun(1, 2, 3, 4, 5000, 5001),
+		"bmp": newBmp(1, 2, 3, 5000),
+	} {
+		t.Run(name, func(t *testing.T) {
+			c1 := newBmp(seq(0, 4000, 2)...)
+			expect := bitmapOf(newBmp(seq(0, 4000, 2)...))
+			expect.Or(bitmapOf(c2))
+
+			c1.Size = 12345 // deliberately stale
+			rb := bitmapOf(c1)
+			rb.Or(bitmapOf(c2))
+			assert.Equal(t, uint32(len(valuesOf(expect))), rb.containers[0].Size)
+			assert.NoError(t, rb.Validate())
+		})
+	}
+}
+
+func TestOrRuns(t *testing.T) {
+	tc := []struct {
+		name string
+		runs [][2]uint32
+	}{
+		{"empty", nil},
+		{"single", [][2]uint32{{10, 20}}},
+		{"overlapping", [][2]uint32{{10, 20}, {15, 30}, {0, 12}}},
+		{"adjacent", [][2]uint32{{21, 30}, {10, 20}, {31, 31}}},
+		{"nested", [][2]uint32{{0, 100000}, {10, 20}, {65535, 65536}}},
+		{"disjoint", [][2]uint32{{200000, 300000}, {5, 6}, {70000, 70001}}},
+		{"inverted", [][2]uint32{{20, 10}, {1, 2}}},
+		{"boundary", [][2]uint32{{4294967290, 4294967295}, {4294967295, 4294967295}, {0, 0}}},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			rb, expect := New(), New()
+			rb.Set(50)
+			expect.Set(50)
+
+			input := slices.Clone(tt.runs)
+			rb.OrRuns(tt.runs)
+			for _, r := range tt.runs {
+				expect.AddRangeClosed(r[0], r[1])
+			}
+
+			bitmapsEqual(t, expect, rb)
+			assert.NoError(t, rb.Validate())
+			assert.Equal(t, input, tt.runs, "input must not be modified")
+		})
+	}
+}
+
+func BenchmarkOrRuns(b *testing.B) {
+	runs := make([][2]uint32, 0, 10000)
+	for i := 0; i < cap(runs); i++ {
+		lo := uint32(rand.IntN(1 << 20))
+		runs = append(runs, [2]uint32{lo, lo + uint32(rand.IntN(1000))})
+	}
+
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			New().OrRuns(runs)
+		}
+	})
+
+	b.Run("closed", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rb := New()
+			for _, r := range runs {
+				rb.AddRangeClosed(r[0], r[1])
+			}
+		}
+	})
+}
+
+func TestBmpCountKernels(t *testing.T) {
+	random := func() bitmap.Bitmap {
+		out := make(bitmap.Bitmap, bitmapSize/4)
+		for i := range out {
+			out[i] = rand.Uint64() & rand.Uint64()
+		}
+		return out
+	}
+
+	tc := []struct {
+		name   string
+		fused  func(a, b bitmap.Bitmap) int
+		expect func(a, b bitmap.Bitmap)
+	}{
+		{"or", bmpOrCount, func(a, b bitmap.Bitmap) { a.Or(b) }},
+		{"and", bmpAndCount, func(a, b bitmap.Bitmap) { a.And(b) }},
+		{"andnot", bmpAndNotCount, func(a, b bitmap.Bitmap) { a.AndNot(b) }},
+		{"xor", bmpXorCount, func(a, b bitmap.Bitmap) { a.Xor(b) }},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				a, b := random(), random()
+				expect := slices.Clone(a)
+				tt.expect(expect, b)
+
+				assert.Equal(t, expect.Count(), tt.fused(a, b))
+				assert.Equal(t, expect, a)
+			}
+		})
+	}
+}
+
+func BenchmarkOrBmpChain(b *testing.B) {
+	inputs := make([]*Bitmap, 64)
+	for i := range inputs {
+		inputs[i] = New()
+		for key := uint32(0); key < 16; key++ {
+			for v := uint32(i); v < 1<<16; v += 3 + uint32(i%4) {
+				inputs[i].Set(key<<16 | v)
+			}
+		}
+	}
+
+	dst := New()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		inputs[0].Clone(dst)
+		for _, other := range inputs[1:] {
+			dst.Or(other)
+		}
+	}
+}
+
+func BenchmarkBmpOrCount(b *testing.B) {
+	x, y := make(bitmap.Bitmap, bitmapSize/4), make(bitmap.Bitmap, bitmapSize/4)
+	for i := range x {
+		x[i], y[i] = rand.Uint64(), rand.Uint64()
+	}
+
+	b.Run("fused", func(b *testing.B) {
+		a := slices.Clone(x)
+		for i := 0; i < b.N; i++ {
+			bmpOrCount(a, y)
+		}
+	})
+
+	b.Run("separate", func(b *testing.B) {
+		a := slices.Clone(x)
+		for i := 0; i < b.N; i++ {
+			a.Or(y)
+			a.Count()
+		}
+	})
+}
+
 func TestOr(t *testing.T) {
 	tc := []struct {
 		name   string
@@ -291,6 +900,7 @@ func TestOr(t *testing.T) {
 
 		// Complex overlapping patterns
 		{"run ∨ run complex", newRun(1, 2, 3, 4, 5, 10, 11, 12), newRun(3, 4, 5, 6, 7, 11, 12, 13), []uint16{1, 2, 3, 4, 5, 6, 7, 10, 11, 12, 13}},
+		{"run ∨ run cascading", newRun(1, 2, 3, 5, 6, 8, 9, 10), newRun(3, 4, 5, 7, 8), []uint16{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
 	}
 
 	for _, tt := range tc {
@@ -307,6 +917,116 @@ func TestOr(t *testing.T) {
 	}
 }
 
+func TestOrEmptyNoAlloc(t *testing.T) {
+	a, b := New(), New()
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		a.Or(b)
+		a.Or(nil)
+	}))
+
+	// An allocated but cleared bitmap reuses its capacity
+	a, b = makeTestBitmap(), makeTestBitmap()
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		a.Clear()
+		a.Or(b)
+	}))
+	bitmapsEqual(t, b, a)
+}
+
+func TestOrInPlace(t *testing.T) {
+	rb := New()
+	for k := uint32(0); k < 100; k += 2 {
+		rb.Set(k<<16 | 1)
+	}
+
+	// Keys which already exist are merged in place
+	subset := FromArray([]uint32{2<<16 | 5, 10<<16 | 5, 98<<16 | 5})
+	expect := append(rb.ToArray(), subset.ToArray()...)
+	slices.Sort(expect)
+	index := &rb.index[0]
+	rb.Or(subset)
+	assert.Equal(t, expect, rb.ToArray())
+	assert.Same(t, index, &rb.index[0])
+
+	// New keys are inserted in between the existing ones
+	delta := FromArray([]uint32{1 << 16, 3<<16 | 1, 10<<16 | 6, 99 << 16, 200 << 16})
+	expect = append(expect, delta.ToArray()...)
+	slices.Sort(expect)
+	rb.Or(delta)
+	assert.Equal(t, expect, rb.ToArray())
+	assert.NoError(t, rb.Validate())
+}
+
+func BenchmarkOrDelta(b *testing.B) {
+	base := New()
+	for k := uint32(0); k < 10000; k++ {
+		base.Set(k<<16 | 1)
+	}
+
+	existing, added := New(), New()
+	for k := uint32(0); k < 100; k++ {
+		existing.Set(k*100<<16 | 2)
+		added.Set((10000+k)<<16 | 2)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		delta *Bitmap
+	}{
+		{"existing-keys", existing},
+		{"new-keys", added},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			rb := base.Clone(nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rb.Or(tc.delta)
+			}
+		})
+	}
+}
+
+func TestEmptyNoAlloc(t *testing.T) {
+	ops := map[string]func(rb, other *Bitmap){
+		"and":    func(rb, other *Bitmap) { rb.And(other) },
+		"andnot": func(rb, other *Bitmap) { rb.AndNot(other) },
+		"or":     func(rb, other *Bitmap) { rb.Or(other) },
+		"xor":    func(rb, other *Bitmap) { rb.Xor(other) },
+	}
+
+	for name, op := range ops {
+		t.Run(name, func(t *testing.T) {
+			empty, full := New(), makeTestBitmap()
+			assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+				op(empty, empty)
+				op(empty, nil)
+			}), "empty receiver, empty operand")
+
+			assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+				op(full, empty)
+				op(full, nil)
+			}), "empty operand")
+
+			// An allocated but cleared bitmap reuses its capacity
+			rb, other := makeTestBitmap(), makeTestBitmap()
+			assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+				rb.Clear()
+				op(rb, other)
+			}), "empty receiver")
+		})
+	}
+
+	t.Run("results", func(t *testing.T) {
+		for _, op := range []string{"or", "xor"} {
+			rb := makeTestBitmap()
+			rb.Clear()
+			ops[op](rb, makeTestBitmap())
+			bitmapsEqual(t, makeTestBitmap(), rb)
+		}
+	})
+}
+
 func TestXor(t *testing.T) {
 	tc := []struct {
 		name   string
@@ -369,6 +1089,9 @@ func TestXor(t *testing.T) {
 		{"run ⊕ bmp boundary", newRun(0, 1, 65535), newBmp(0, 65535), []uint16{1}},
 		{"run ⊕ run boundary", newRun(0, 1, 65535), newRun(0, 65535), []uint16{1}},
 
+		// Interleaved values, which must stay sorted
+		{"arr ⊕ run interleaved", newArr(1, 6, 10, 20), newRun(5, 6, 7, 15, 16), []uint16{1, 5, 7, 10, 15, 16, 20}},
+
 		// One side empty (XOR with empty = identity)
 		{"arr ⊕ empty", newArr(1, 2, 3), newArr(), []uint16{1, 2, 3}},
 		{"bmp ⊕ empty", newBmp(1, 2, 3), newArr(), []uint16{1, 2, 3}},
@@ -403,3 +1126,778 @@ func TestXor(t *testing.T) {
 		})
 	}
 }
+
+func TestOrValues(t *testing.T) {
+	for _, gen := range []dataGen{
+		genSeq(100000, 0),
+		genRand(10000, 1000000),
+		genSparse(1000),
+		genDense(10000),
+		genBoundary(),
+		genMixed(),
+	} {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			values := slices.Clone(data)
+			slices.Sort(values)
+
+			// Merge into an existing bitmap with overlapping values
+			expect, _ := testPair(append(data, 7, 65536*5))
+			our := New()
+			our.Set(7)
+			our.Set(65536 * 5)
+			our.OrValues(values)
+			bitmapsEqual(t, expect, our)
+			assert.NoError(t, our.Validate())
+
+			// Build directly from an unsorted slice
+			expect, _ = testPair(data)
+			from := FromArray(data)
+			bitmapsEqual(t, expect, from)
+			assert.NoError(t, from.Validate())
+		})
+	}
+}
+
+func TestOrValuesUnsorted(t *testing.T) {
+	values := []uint32{5, 3 << 16, 1, 3<<16 | 2, 2 << 16, 5, 0}
+	input := slices.Clone(values)
+
+	rb := New()
+	rb.OrValues(values)
+	assert.Equal(t, []uint32{0, 1, 5, 2 << 16, 3 << 16, 3<<16 | 2}, rb.ToArray())
+	assert.Equal(t, input, values)
+	assert.NoError(t, rb.Validate())
+
+	other := New()
+	other.SetManySorted(values)
+	bitmapsEqual(t, rb, other)
+}
+
+func BenchmarkOrValues(b *testing.B) {
+	data, _ := genRand(100000, 10000000)()
+	base, _ := testPair(data)
+	values, _ := genRand(10000, 10000000)()
+	slices.Sort(values)
+
+	b.Run("or-values", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rb := base.Clone(nil)
+			rb.OrValues(values)
+		}
+	})
+
+	b.Run("or-from-array", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rb := base.Clone(nil)
+			rb.Or(FromArray(values))
+		}
+	})
+}
+
+func TestAndNotValues(t *testing.T) {
+	for _, gen := range []dataGen{
+		genSeq(100000, 0),
+		genRand(10000, 1000000),
+		genSparse(1000),
+		genDense(10000),
+		genBoundary(),
+		genMixed(),
+	} {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+				our, _ := changeType(typ)
+				expect, _ := changeType(typ)
+				our.OrValues(slices.Sorted(slices.Values(data)))
+				expect.OrValues(slices.Sorted(slices.Values(data)))
+
+				// Remove every other value, plus a few that are not present
+				remove := []uint32{3, 65536 * 7}
+				for i := 0; i < len(data); i += 2 {
+					remove = append(remove, data[i])
+				}
+
+				for _, v := range remove {
+					expect.Remove(v)
+				}
+
+				slices.Sort(remove)
+				our.AndNotValues(remove)
+				bitmapsEqual(t, expect, our)
+				assert.NoError(t, our.Validate())
+			}
+		})
+	}
+
+	t.Run("remove all", func(t *testing.T) {
+		data, _ := genMixed()()
+		our := FromArray(data)
+		our.AndNotValues(data)
+		assert.Equal(t, 0, our.Count())
+		assert.Equal(t, 0, len(our.containers))
+	})
+
+	t.Run("drop containers", func(t *testing.T) {
+		data := seq(0, 1<<26, 1<<12)
+		remove := append(seq(0, 1<<16, 1<<12), seq(3<<16, 1<<26, 3<<16)...)
+		slices.Sort(remove)
+
+		our, expect := FromArray(data), FromArray(data)
+		for _, v := range remove {
+			expect.Remove(v)
+		}
+
+		our.AndNotValues(remove)
+		bitmapsEqual(t, expect, our)
+		assert.NoError(t, our.Validate())
+		assert.Equal(t, len(expect.containers), len(our.containers))
+	})
+}
+
+func TestAndNotValuesUnsorted(t *testing.T) {
+	values := []uint32{3 << 16, 5, 1, 3<<16 | 2, 5, 0}
+	input := slices.Clone(values)
+
+	rb := FromArray([]uint32{0, 1, 2, 5, 2 << 16, 3 << 16, 3<<16 | 1, 3<<16 | 2})
+	rb.AndNotValues(values)
+	assert.Equal(t, []uint32{2, 2 << 16, 3<<16 | 1}, rb.ToArray())
+	assert.Equal(t, input, values)
+	assert.NoError(t, rb.Validate())
+}
+
+func BenchmarkAndNotValues(b *testing.B) {
+	data, _ := genRand(100000, 10000000)()
+	base, _ := testPair(data)
+	values := slices.Clone(data[:10000])
+	slices.Sort(values)
+
+	b.Run("and-not-values", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rb := base.Clone(nil)
+			rb.AndNotValues(values)
+		}
+	})
+
+	b.Run("remove", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rb := base.Clone(nil)
+			for _, v := range values {
+				rb.Remove(v)
+			}
+		}
+	})
+}
+
+// BenchmarkAndNotValuesDrop removes values which empty every other container, so that
+// thousands of containers are dropped from the middle of the index
+func BenchmarkAndNotValuesDrop(b *testing.B) {
+	data := seq(0, 1<<32-1, 1<<16)
+	values := seq(0, 1<<32-1, 1<<17)
+
+	b.Run("and-not-values", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			rb := FromArray(data)
+			b.StartTimer()
+			rb.AndNotValues(values)
+		}
+	})
+
+	b.Run("remove", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			rb := FromArray(data)
+			b.StartTimer()
+			for _, v := range values {
+				rb.Remove(v)
+			}
+		}
+	})
+}
+
+func TestContainsAllOf(t *testing.T) {
+	ctors := map[string]func(...uint32) *container{"arr": newArr, "bmp": newBmp, "run": newRun}
+	for n1, new1 := range ctors {
+		for n2, new2 := range ctors {
+			t.Run(n1+" ⊇ "+n2, func(t *testing.T) {
+				a, _ := bitmapWith(new1(1, 2, 3, 4, 5, 10, 11, 12, 65535))
+				assert.True(t, a.ContainsAllOf(bitmapOf(new2(2, 3, 4, 11, 65535))))
+				assert.True(t, a.ContainsAllOf(bitmapOf(new2(1, 2, 3, 4, 5, 10, 11, 12, 65535))))
+				assert.False(t, a.ContainsAllOf(bitmapOf(new2(4, 5, 6))))
+				assert.False(t, a.ContainsAllOf(bitmapOf(new2(0))))
+				assert.False(t, a.ContainsAllOf(bitmapOf(new2(9, 10, 11))))
+			})
+		}
+	}
+
+	t.Run("containers", func(t *testing.T) {
+		rb := makeTestBitmap()
+		assert.True(t, rb.ContainsAllOf(rb))
+		assert.True(t, rb.ContainsAllOf(New()))
+		assert.True(t, rb.ContainsAllOf(nil))
+		assert.True(t, rb.ContainsAllOf(FromArray([]uint32{1, 10, 131072, 4294967295})))
+		assert.False(t, rb.ContainsAllOf(FromArray([]uint32{1, 10, 3 << 16})))
+		assert.False(t, New().ContainsAllOf(rb))
+	})
+}
+
+func TestIsSubset(t *testing.T) {
+	ctors := map[string]func(...uint32) *container{"arr": newArr, "bmp": newBmp, "run": newRun}
+	data := []struct {
+		name             string
+		a, b             []uint32
+		subset, superset bool
+	}{
+		{"equal", []uint32{0, 1, 2, 3, 10, 11, 65535}, []uint32{0, 1, 2, 3, 10, 11, 65535}, true, true},
+		{"strict", []uint32{1, 2, 11, 65535}, []uint32{0, 1, 2, 3, 10, 11, 65535}, true, false},
+		{"dense", seq(100, 60000, 3), seq(0, 65536, 1), true, false},
+		{"partial", []uint32{1, 2, 3, 4}, []uint32{3, 4, 5, 6}, false, false},
+		{"disjoint", []uint32{1, 2, 3}, []uint32{4, 5, 6}, false, false},
+	}
+
+	for n1, new1 := range ctors {
+		for n2, new2 := range ctors {
+			for _, d := range data {
+				t.Run(n1+" ⊆ "+n2+" "+d.name, func(t *testing.T) {
+					a, av := bitmapWith(new1(d.a...))
+					b, bv := bitmapWith(new2(d.b...))
+					assert.Equal(t, d.subset, a.IsSubset(b))
+					assert.Equal(t, d.superset, a.IsSuperset(b))
+					assert.Equal(t, d.subset, b.IsSuperset(a))
+					assert.Equal(t, d.superset, b.IsSubset(a))
+
+					// Neither operand is modified
+					assert.Equal(t, av, valuesOf(a))
+					assert.Equal(t, bv, valuesOf(b))
+				})
+			}
+		}
+	}
+
+	t.Run("containers", func(t *testing.T) {
+		rb := makeTestBitmap()
+		assert.True(t, rb.IsSubset(rb))
+		assert.True(t, rb.IsSuperset(rb))
+		assert.True(t, New().IsSubset(rb))
+		assert.True(t, New().IsSubset(nil))
+		assert.True(t, rb.IsSuperset(nil))
+		assert.False(t, rb.IsSubset(nil))
+		assert.False(t, rb.IsSubset(New()))
+
+		// A key missing from the other bitmap is never a subset
+		sub := FromArray([]uint32{1, 10, 131072, 3 << 16})
+		assert.False(t, sub.IsSubset(rb))
+		sub.Remove(3 << 16)
+		assert.True(t, sub.IsSubset(rb))
+		assert.True(t, rb.IsSuperset(sub))
+	})
+}
+
+func TestEquals(t *testing.T) {
+	ctors := map[string]func(...uint32) *container{"arr": newArr, "bmp": newBmp, "run": newRun}
+	data := []struct {
+		name string
+		a, b []uint32
+	}{
+		{"sparse", []uint32{0, 1, 2, 10, 11, 12, 65535}, []uint32{0, 1, 2, 10, 11, 13, 65535}},
+		{"first", []uint32{0, 100, 200}, []uint32{1, 100, 200}},
+		{"last", []uint32{0, 100, 65535}, []uint32{0, 100, 65534}},
+		{"dense", seq(0, 65536, 3), append(seq(0, 65535, 3), 65534)},
+	}
+
+	for n1, new1 := range ctors {
+		for n2, new2 := range ctors {
+			for _, d := range data {
+				t.Run(n1+" = "+n2+" "+d.name, func(t *testing.T) {
+					a, av := bitmapWith(new1(d.a...))
+					assert.True(t, a.Equals(bitmapOf(new2(d.a...))))
+					assert.True(t, bitmapOf(new2(d.b...)).Equals(bitmapOf(new1(d.b...))))
+
+					// Of the same size, but differing by a single value
+					assert.False(t, a.Equals(bitmapOf(new2(d.b...))))
+					assert.False(t, bitmapOf(new2(d.b...)).Equals(a))
+					assert.Equal(t, av, valuesOf(a))
+				})
+			}
+		}
+	}
+
+	t.Run("containers", func(t *testing.T) {
+		rb := makeTestBitmap()
+		assert.True(t, rb.Equals(rb))
+		assert.True(t, rb.Equals(FromArray(rb.ToArray())))
+		assert.True(t, New().Equals(New()))
+		assert.True(t, New().Equals(nil))
+		assert.False(t, rb.Equals(nil))
+		assert.False(t, rb.Equals(New()))
+
+		// Same count, but a value moved to a different key
+		other := makeTestBitmap()
+		other.Remove(1)
+		other.Set(3 << 16)
+		assert.Equal(t, rb.Count(), other.Count())
+		assert.False(t, rb.Equals(other))
+	})
+}
+
+func TestContainsRange(t *testing.T) {
+	rb := FromArray(seq(100, 200, 1))
+	rb.AddRange(1<<16-10, 3<<16+10)
+	rb.AddRange(5<<16, 5<<16+40000)
+	for _, v := range seq(5<<16+40000, 6<<16, 2) {
+		rb.Set(v)
+	}
+	rb.Remove(5<<16 + 1000)
+	rb.Set(4294967295)
+
+	types := make([]ctype, 0, len(rb.containers))
+	for _, c := range rb.containers {
+		types = append(types, c.Type)
+	}
+	assert.Equal(t, []ctype{typeArray, typeRun, typeRun, typeRun, typeBitmap, typeArray}, types)
+
+	tc := []struct {
+		lo, hi uint32
+		expect bool
+	}{
+		{100, 200, true},
+		{150, 151, true},
+		{99, 200, false},
+		{100, 201, false},
+		{1<<16 - 10, 3<<16 + 10, true},
+		{1<<16 - 11, 3<<16 + 10, false},
+		{1 << 16, 3 << 16, true},
+		{2<<16 - 1, 2<<16 + 1, true},
+		{3<<16 + 5, 3<<16 + 11, false},
+		{5 << 16, 5<<16 + 1000, true},
+		{5 << 16, 5<<16 + 1001, false},
+		{5<<16 + 1001, 5<<16 + 40000, true},
+		{5<<16 + 1001, 5<<16 + 40002, false},
+		{5<<16 + 40000, 5<<16 + 40001, true},
+		{4 << 16, 6 << 16, false},
+		{4294967295, 4294967295, true},
+		{4294967294, 4294967295, false},
+		{10, 10, true},
+		{20, 10, true},
+	}
+
+	for _, tt := range tc {
+		expect := true
+		for v := uint64(tt.lo); v < uint64(tt.hi); v++ {
+			expect = expect && rb.Contains(uint32(v))
+		}
+
+		assert.Equal(t, expect, tt.expect, "[%d, %d)", tt.lo, tt.hi)
+		assert.Equal(t, tt.expect, rb.ContainsRange(tt.lo, tt.hi), "[%d, %d)", tt.lo, tt.hi)
+	}
+
+	// Every container type covering the same values
+	for typ, c := range map[string]*container{
+		"arr": newArr(seq(64, 200, 1)...),
+		"bmp": newBmp(seq(64, 200, 1)...),
+		"run": newRun(seq(64, 200, 1)...),
+	} {
+		t.Run(typ, func(t *testing.T) {
+			rb := bitmapOf(c)
+			assert.True(t, rb.ContainsRange(64, 200))
+			assert.True(t, rb.ContainsRange(127, 129))
+			assert.False(t, rb.ContainsRange(63, 129))
+			assert.False(t, rb.ContainsRange(127, 201))
+			assert.False(t, rb.ContainsRange(64, 1<<16+1))
+		})
+	}
+
+	// Adjacent runs which were never merged, as accepted when decoding
+	split := New()
+	split.ctrAdd(0, 0, &container{Type: typeRun, Size: 25, Data: []uint16{0, 9, 10, 19, 21, 25}})
+	assert.NoError(t, split.Validate())
+	assert.True(t, split.ContainsRange(0, 20))
+	assert.True(t, split.ContainsRange(5, 15))
+	assert.False(t, split.ContainsRange(5, 21))
+	assert.False(t, split.ContainsRange(15, 23))
+	assert.True(t, split.IsSubset(FromArray(seq(0, 30, 1))))
+	assert.True(t, FromArray(seq(0, 20, 1)).IsSubset(split))
+	assert.True(t, split.Equals(FromArray(append(seq(0, 20, 1), seq(21, 26, 1)...))))
+	assert.True(t, bitmapOf(newRun(append(seq(0, 20, 1), seq(21, 26, 1)...)...)).Equals(split))
+
+	assert.True(t, New().ContainsRange(5, 5))
+	assert.False(t, New().ContainsRange(5, 6))
+}
+
+func TestBucketContains(t *testing.T) {
+	for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+		rb, values := changeType(typ)
+
+		var bucket bitmap.Bitmap
+		for _, v := range values[:len(values)/2] {
+			bucket.Set(v)
+		}
+
+		assert.True(t, rb.BucketContains(0, bucket))
+		assert.True(t, rb.BucketContains(0, nil))
+		assert.False(t, rb.BucketContains(1, bucket))
+		assert.True(t, rb.BucketContains(1, nil))
+
+		bucket.Set(values[len(values)-1] + 1)
+		assert.False(t, rb.BucketContains(0, bucket))
+
+		// Values beyond the container range are never contained
+		bucket.Remove(values[len(values)-1] + 1)
+		bucket.Set(70000)
+		assert.False(t, rb.BucketContains(0, bucket))
+	}
+}
+
+func TestAndCardinality(t *testing.T) {
+	ctors := map[string]func(...uint32) *container{"arr": newArr, "bmp": newBmp, "run": newRun}
+	data := []struct {
+		name string
+		a, b []uint32
+	}{
+		{"overlap", []uint32{1, 2, 3, 4, 5, 63, 64, 65, 100, 1000, 65535}, []uint32{0, 3, 4, 5, 6, 64, 128, 1000, 1001, 65535}},
+		{"identical", []uint32{1, 2, 3, 10, 11, 12}, []uint32{1, 2, 3, 10, 11, 12}},
+		{"disjoint", []uint32{1, 2, 3}, []uint32{4, 5, 6}},
+		{"boundary", []uint32{0, 1, 65535}, []uint32{0, 65535}},
+		{"dense", seq(0, 65536, 1), seq(100, 60000, 3)},
+	}
+
+	for n1, new1 := range ctors {
+		for n2, new2 := range ctors {
+			for _, d := range data {
+				t.Run(n1+" ∧ "+n2+" "+d.name, func(t *testing.T) {
+					a, av := bitmapWith(new1(d.a...))
+					b, bv := bitmapWith(new2(d.b...))
+
+					expect := a.Clone(nil)
+					expect.And(b)
+					assert.Equal(t, expect.Count(), a.AndCardinality(b))
+					assert.Equal(t, expect.Count(), b.AndCardinality(a))
+
+					// Neither operand is modified
+					assert.Equal(t, av, valuesOf(a))
+					assert.Equal(t, bv, valuesOf(b))
+				})
+			}
+		}
+	}
+
+	rb := makeTestBitmap()
+	assert.Equal(t, 0, rb.AndCardinality(nil))
+	assert.Equal(t, 0, rb.AndCardinality(New()))
+	assert.Equal(t, 0, New().AndCardinality(rb))
+	assert.Equal(t, 0, (*Bitmap)(nil).AndCardinality(rb))
+	assert.Equal(t, 0, (*Bitmap)(nil).AndCardinality(nil))
+	assert.Equal(t, rb.Count(), rb.AndCardinality(rb))
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() {
+		rb.AndCardinality(rb)
+	}))
+}
+
+func TestOrCardinality(t *testing.T) {
+	gens := []dataGen{genSeq(1000, 0), genRand(10000, 1<<20), genSparse(1000), genDense(10000), genBoundary(), genMixed()}
+	for _, gen1 := range gens {
+		for _, gen2 := range gens {
+			data1, name1 := gen1()
+			data2, name2 := gen2()
+			t.Run(name1+" ∨ "+name2, func(t *testing.T) {
+				a, b := FromArray(data1), FromArray(data2)
+				a.Optimize()
+
+				expect := a.Clone(nil)
+				expect.Or(b)
+				assert.Equal(t, expect.Count(), a.OrCardinality(b))
+				assert.Equal(t, expect.Count(), b.OrCardinality(a))
+				bitmapsEqual(t, FromArray(data1), a)
+				bitmapsEqual(t, FromArray(data2), b)
+			})
+		}
+	}
+
+	rb := makeTestBitmap()
+	assert.Equal(t, rb.Count(), rb.OrCardinality(nil))
+	assert.Equal(t, rb.Count(), rb.OrCardinality(New()))
+	assert.Equal(t, rb.Count(), New().OrCardinality(rb))
+	assert.Equal(t, rb.Count(), rb.OrCardinality(rb))
+}
+
+func TestXorAndNotCardinality(t *testing.T) {
+	ctors := map[string]func(...uint32) *container{"arr": newArr, "bmp": newBmp, "run": newRun}
+	data := []struct {
+		name string
+		a, b []uint32
+	}{
+		{"overlap", []uint32{1, 2, 3, 4, 5, 63, 64, 65, 100, 1000, 65535}, []uint32{0, 3, 4, 5, 6, 64, 128, 1000, 1001, 65535}},
+		{"identical", []uint32{1, 2, 3, 10, 11, 12}, []uint32{1, 2, 3, 10, 11, 12}},
+		{"disjoint", []uint32{1, 2, 3}, []uint32{4, 5, 6}},
+		{"subset", seq(0, 65536, 1), seq(100, 60000, 3)},
+	}
+
+	for n1, new1 := range ctors {
+		for n2, new2 := range ctors {
+			for _, d := range data {
+				t.Run(n1+" vs "+n2+" "+d.name, func(t *testing.T) {
+					// Add keys present in only one or in both of the bitmaps
+					a, b := bitmapOf(new1(d.a...)), bitmapOf(new2(d.b...))
+					a.Or(FromArray([]uint32{1 << 16, 3 << 16}))
+					b.Or(FromArray([]uint32{2 << 16, 3 << 16}))
+					av, bv := a.ToArray(), b.ToArray()
+
+					xor, andNot := a.Clone(nil), a.Clone(nil)
+					xor.Xor(b)
+					andNot.AndNot(b)
+					assert.Equal(t, xor.Count(), a.XorCardinality(b))
+					assert.Equal(t, xor.Count(), b.XorCardinality(a))
+					assert.Equal(t, andNot.Count(), a.AndNotCardinality(b))
+
+					// Neither operand is modified
+					assert.Equal(t, av, a.ToArray())
+					assert.Equal(t, bv, b.ToArray())
+				})
+			}
+		}
+	}
+
+	rb := makeTestBitmap()
+	assert.Equal(t, rb.Count(), rb.XorCardinality(nil))
+	assert.Equal(t, rb.Count(), rb.AndNotCardinality(nil))
+	assert.Equal(t, 0, rb.XorCardinality(rb))
+	assert.Equal(t, 0, rb.AndNotCardinality(rb))
+	assert.Equal(t, 0, New().AndNotCardinality(rb))
+}
+
+func TestIntersects(t *testing.T) {
+	ctors := map[string]func(...uint32) *container{"arr": newArr, "bmp": newBmp, "run": newRun}
+	data := []struct {
+		name   string
+		a, b   []uint32
+		expect bool
+	}{
+		{"disjoint", []uint32{1, 2, 3, 100}, []uint32{4, 5, 6, 99, 101}, false},
+		{"interleaved", seq(0, 65536, 2), seq(1, 65536, 2), false},
+		{"single", []uint32{1, 2, 3, 1000, 2000}, []uint32{500, 2000, 3000}, true},
+		{"first", []uint32{0, 10, 20}, []uint32{0, 5}, true},
+		{"last", []uint32{10, 20, 65535}, []uint32{5, 65535}, true},
+		{"subset", seq(0, 65536, 1), seq(100, 60000, 3), true},
+	}
+
+	for n1, new1 := range ctors {
+		for n2, new2 := range ctors {
+			for _, d := range data {
+				t.Run(n1+" ∧ "+n2+" "+d.name, func(t *testing.T) {
+					a, av := bitmapWith(new1(d.a...))
+					b, bv := bitmapWith(new2(d.b...))
+					assert.Equal(t, d.expect, a.Intersects(b))
+					assert.Equal(t, d.expect, b.Intersects(a))
+					assert.Equal(t, a.AndCardinality(b) > 0, a.Intersects(b))
+
+					// Neither operand is modified
+					assert.Equal(t, av, valuesOf(a))
+					assert.Equal(t, bv, valuesOf(b))
+				})
+			}
+		}
+	}
+
+	// Only a single value of a single shared key overlaps
+	a, b := makeTestBitmap(), FromArray([]uint32{2, 7 << 16, 131072 + 999, 1 << 30})
+	assert.True(t, a.Intersects(b))
+	assert.True(t, b.Intersects(a))
+	assert.False(t, a.Intersects(FromArray([]uint32{2, 0xFFFF + 1, 1 << 30})))
+	assert.False(t, a.Intersects(FromArray([]uint32{3 << 16, 4294967294})))
+	assert.True(t, a.Intersects(FromArray([]uint32{4294967295})))
+
+	assert.False(t, a.Intersects(nil))
+	assert.False(t, a.Intersects(New()))
+	assert.False(t, New().Intersects(a))
+	assert.True(t, a.Intersects(a))
+}
+
+func TestDisjoint(t *testing.T) {
+	gens := []dataGen{genSeq(1000, 0), genRand(10000, 1<<20), genSparse(1000), genDense(10000), genBoundary(), genMixed()}
+	for _, gen1 := range gens {
+		for _, gen2 := range gens {
+			data1, name1 := gen1()
+			data2, name2 := gen2()
+			t.Run(name1+" vs "+name2, func(t *testing.T) {
+				a, b := FromArray(data1), FromArray(data2)
+				assert.Equal(t, !a.Intersects(b), a.Disjoint(b))
+				assert.Equal(t, a.AndCardinality(b) == 0, a.Disjoint(b))
+				assert.Equal(t, a.Disjoint(b), b.Disjoint(a))
+			})
+		}
+	}
+
+	a := FromArray([]uint32{1, 5, 1000, 1 << 20})
+	assert.True(t, a.Disjoint(FromArray([]uint32{2, 6, 1001, 1<<20 + 1})))
+	assert.False(t, a.Disjoint(FromArray([]uint32{2, 6, 1001, 1 << 20})))
+	assert.False(t, a.Disjoint(a))
+	assert.True(t, a.Disjoint(nil))
+	assert.True(t, a.Disjoint(New()))
+	assert.True(t, New().Disjoint(a))
+
+	// Stops at the first shared value without allocating
+	b := makeTestBitmap()
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() {
+		a.Disjoint(b)
+		b.Disjoint(b)
+	}))
+}
+
+func TestCompare(t *testing.T) {
+	ctors := map[string]func(...uint32) *container{"arr": newArr, "bmp": newBmp, "run": newRun}
+	for n1, new1 := range ctors {
+		for n2, new2 := range ctors {
+			t.Run(n1+" vs "+n2, func(t *testing.T) {
+				a := bitmapOf(new1(1, 2, 3, 4, 5, 63, 64, 65, 100, 1000, 65535))
+				b := bitmapOf(new2(0, 3, 4, 5, 6, 64, 128, 1000, 1001, 65535))
+				assertCompare(t, a, b)
+			})
+		}
+	}
+
+	t.Run("containers", func(t *testing.T) {
+		data1, _ := genRand(10000, 1<<20)()
+		data2, _ := genRand(10000, 1<<20)()
+		a, b := FromArray(data1), FromArray(data2)
+		a.Or(makeTestBitmap())
+		b.AddRange(131072, 131072+500)
+		a.Optimize()
+		b.Optimize()
+		assertCompare(t, a, b)
+		assertCompare(t, a, New())
+		assertCompare(t, New(), b)
+
+		aOnly, both, bOnly := a.Compare(nil)
+		assert.Equal(t, []int{a.Count(), 0, 0}, []int{aOnly, both, bOnly})
+	})
+}
+
+// assertCompare validates the partition against set operations on clones
+func assertCompare(t *testing.T, a, b *Bitmap) {
+	t.Helper()
+	and, andNot, notAnd := a.Clone(nil), a.Clone(nil), b.Clone(nil)
+	and.And(b)
+	andNot.AndNot(b)
+	notAnd.AndNot(a)
+
+	aOnly, both, bOnly := a.Compare(b)
+	assert.Equal(t, andNot.Count(), aOnly)
+	assert.Equal(t, and.Count(), both)
+	assert.Equal(t, notAnd.Count(), bOnly)
+}
+
+func TestJaccardMatrix(t *testing.T) {
+	dense := New()
+	dense.AddRange(0, 100000)
+	matrix := JaccardMatrix([]*Bitmap{
+		FromArray([]uint32{1, 2, 3, 4}),
+		FromArray([]uint32{3, 4, 5, 6}),
+		FromArray([]uint32{1, 2, 3, 4}),
+		New(),
+		dense,
+	})
+
+	assert.Equal(t, [][]float64{
+		{1, 2.0 / 6, 1, 0, 4.0 / 100000},
+		{2.0 / 6, 1, 2.0 / 6, 0, 4.0 / 100000},
+		{1, 2.0 / 6, 1, 0, 4.0 / 100000},
+		{0, 0, 0, 1, 0},
+		{4.0 / 100000, 4.0 / 100000, 4.0 / 100000, 0, 1},
+	}, matrix)
+	assert.Empty(t, JaccardMatrix(nil))
+}
+
+func TestCountRange(t *testing.T) {
+	rb := makeTestBitmap()
+	values := rb.ToArray()
+	for _, r := range [][2]uint32{
+		{0, 0}, {10, 5}, {0, 11}, {1, 10}, {2, 65536}, {65535, 65536 + 1000},
+		{100000, 131072 + 500}, {131072, 131072 + 1000}, {0, 4294967295},
+	} {
+		expect := 0
+		for _, v := range values {
+			if v >= r[0] && v < r[1] {
+				expect++
+			}
+		}
+
+		assert.Equal(t, expect, rb.CountRange(r[0], r[1]), "range %v", r)
+		if r[0] < r[1] {
+			assert.Equal(t, int(r[1]-r[0])-expect, rb.AbsentCountInRange(r[0], r[1]), "range %v", r)
+		}
+	}
+}
+
+func TestAbsentCountInRange(t *testing.T) {
+	const universe = uint64(1) << 32
+
+	t.Run("unset", func(t *testing.T) {
+		rb := New()
+		assert.Equal(t, 100, rb.AbsentCountInRange(0, 100))
+		assert.Equal(t, 0, rb.AbsentCountInRange(100, 100))
+		assert.Equal(t, 0, rb.AbsentCountInRange(100, 10))
+		assert.Equal(t, universe, rb.AbsentCountInRange64(0, universe))
+		assert.Equal(t, universe, rb.AbsentCountInRange64(0, universe+10))
+	})
+
+	t.Run("set", func(t *testing.T) {
+		rb := New()
+		rb.AddRangeClosed(0, 4294967295)
+		assert.Equal(t, 0, rb.AbsentCountInRange(0, 4294967295))
+		assert.Equal(t, uint64(0), rb.AbsentCountInRange64(0, universe))
+		assert.Equal(t, uint64(0), rb.AbsentCountInRange64(universe-1, universe))
+		assert.Equal(t, 4294967295, rb.CountRange(0, 4294967295))
+	})
+
+	t.Run("boundary", func(t *testing.T) {
+		rb := New()
+		rb.Set(4294967295)
+		rb.Set(0)
+		assert.Equal(t, 4294967294, rb.AbsentCountInRange(0, 4294967295))
+		assert.Equal(t, universe-2, rb.AbsentCountInRange64(0, universe))
+		assert.Equal(t, uint64(0), rb.AbsentCountInRange64(universe-1, universe))
+		assert.Equal(t, uint64(1), rb.AbsentCountInRange64(universe-2, universe))
+	})
+}
+
+func TestIntersection(t *testing.T) {
+	data1, _ := genRand(20000, 1<<20)()
+	data2, _ := genRand(20000, 1<<20)()
+	a, b := FromArray(data1), FromArray(data2)
+	a.Or(makeTestBitmap())
+	b.AddRange(131072, 131072+500)
+	b.Set(4294967295)
+	a.Optimize()
+
+	expect := a.Clone(nil)
+	expect.And(b)
+
+	before1, before2 := a.ToBytes(), b.ToBytes()
+	snapshot := slices.Clone(a.containers)
+	out, count := a.Intersection(b)
+	bitmapsEqual(t, expect, out)
+	assert.Equal(t, out.Count(), count)
+	assert.NoError(t, out.Validate())
+
+	// Inputs are unchanged
+	assert.Equal(t, before1, a.ToBytes())
+	assert.Equal(t, before2, b.ToBytes())
+	assert.Equal(t, snapshot, a.containers)
+
+	out, count = a.Intersection(nil)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, 0, out.Count())
+}
@@ -9,13 +9,20 @@ func (rb *Bitmap) xor(other *Bitmap) {
 	case other == nil || len(other.containers) == 0:
 		return // No change needed
 	case len(rb.containers) == 0:
-		// Copy all containers from other since A XOR B = B when A is empty
-		rb.containers = make([]container, len(other.containers))
-		rb.index = make([]uint16, len(other.index))
+		// Copy all containers from other since A XOR B = B when A is empty, reusing
+		// the capacity we already have
+		if cap(rb.containers) < len(other.containers) {
+			rb.containers = make([]container, len(other.containers))
+		}
+		if cap(rb.index) < len(other.index) {
+			rb.index = make([]uint16, len(other.index))
+		}
+
+		rb.containers = rb.containers[:len(other.containers)]
+		rb.index = rb.index[:len(other.index)]
 		for i := range other.containers {
-			other.containers[i].Shared = true
+			rb.containers[i] = other.containers[i].share()
 		}
-		copy(rb.containers, other.containers)
 		copy(rb.index, other.index)
 		return
 	}
@@ -35,8 +42,7 @@ func (rb *Bitmap) xor(other *Bitmap) {
 			i++
 		case hi1 > hi2:
 			// Only in right bitmap - copy it
-			other.containers[j].Shared = true
-			newContainers = append(newContainers, other.containers[j])
+			newContainers = append(newContainers, other.containers[j].share())
 			newIndex = append(newIndex, hi2)
 			j++
 		default:
@@ -62,8 +68,7 @@ func (rb *Bitmap) xor(other *Bitmap) {
 
 	// Add remaining containers from right
 	for j < len(other.containers) {
-		other.containers[j].Shared = true
-		newContainers = append(newContainers, other.containers[j])
+		newContainers = append(newContainers, other.containers[j].share())
 		newIndex = append(newIndex, other.index[j])
 		j++
 	}
@@ -75,6 +80,7 @@ func (rb *Bitmap) xor(other *Bitmap) {
 // ctrXor performs efficient XOR between two containers
 func (rb *Bitmap) ctrXor(c1, c2 *container) bool {
 	c1.fork()
+	defer c1.repin(c1.Type)
 	switch c1.Type {
 	case typeArray:
 		switch c2.Type {
@@ -157,35 +163,27 @@ func (rb *Bitmap) arrXorBmp(c1, c2 *container) bool {
 
 // arrXorRun performs XOR between array and run containers
 func (rb *Bitmap) arrXorRun(c1, c2 *container) bool {
-	runs := c2.Data
+	a, runs := c1.Data, c2.Data
 	out := rb.scratch[:0]
+	i := 0
 
-	for _, val := range c1.Data {
-		// Check if value is in any run
-		inRun := false
-		for i := 0; i < len(runs); i += 2 {
-			if uint32(val) >= uint32(runs[i]) && uint32(val) <= uint32(runs[i+1]) {
-				inRun = true
-				break
-			}
-		}
-		if !inRun {
-			out = append(out, val)
+	// Merge the array with the runs in order, keeping values present in exactly one
+	for j := 0; j < len(runs); j += 2 {
+		start, end := uint32(runs[j]), uint32(runs[j+1])
+		for ; i < len(a) && uint32(a[i]) < start; i++ {
+			out = append(out, a[i])
 		}
-	}
 
-	// Add values from runs that are not in array
-	for i := 0; i < len(runs); i += 2 {
-		start, end := uint32(runs[i]), uint32(runs[i+1])
 		for v := start; v <= end; v++ {
-			// Check if value is in array
-			_, found := find16(c1.Data, uint16(v))
-			if !found {
-				out = append(out, uint16(v))
+			if i < len(a) && uint32(a[i]) == v {
+				i++
+				continue
 			}
+			out = append(out, uint16(v))
 		}
 	}
 
+	out = append(out, a[i:]...)
 	c1.Data = append(c1.Data[:0], out...)
 	c1.Size = uint32(len(c1.Data))
 	c1.Type = typeArray
@@ -215,8 +213,7 @@ func (rb *Bitmap) bmpXorBmp(c1, c2 *container) bool {
 		return c1.Size > 0
 	}
 
-	a.Xor(b)
-	c1.Size = uint32(a.Count())
+	c1.Size = uint32(bmpXorCount(a, b))
 	return c1.Size > 0
 }
 
@@ -226,16 +223,7 @@ func (rb *Bitmap) bmpXorRun(c1, c2 *container) bool {
 	runs := c2.Data
 
 	for i := 0; i < len(runs); i += 2 {
-		start, end := uint32(runs[i]), uint32(runs[i+1])
-		for v := start; v <= end; v++ {
-			if bmp.Contains(v) {
-				bmp.Remove(v)
-				c1.Size--
-			} else {
-				bmp.Set(v)
-				c1.Size++
-			}
-		}
+		c1.Size = uint32(int(c1.Size) + bmpFlipRange(bmp, uint32(runs[i]), uint32(runs[i+1])))
 	}
 	return c1.Size > 0
 }
@@ -245,7 +233,7 @@ func (rb *Bitmap) runXorArr(c1, c2 *container) bool {
 	// Convert to array for simpler XOR, then optimize
 	c1.runToArray()
 	result := rb.arrXorArr(c1, c2)
-	c1.optimize()
+	c1.optimize(rb.opts)
 	return result
 }
 
@@ -278,6 +266,6 @@ func (rb *Bitmap) runXorRun(c1, c2 *container) bool {
 	}
 
 	result := rb.arrXorArr(c1, temp)
-	c1.optimize()
+	c1.optimize(rb.opts)
 	return result
 }
@@ -0,0 +1,15 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+//go:build !roaringdebug
+
+package roaring
+
+// assertValid is a no-op unless built with the roaringdebug tag, and is inlined away
+func (rb *Bitmap) assertValid(op string) {}
+
+// assertInsert is a no-op unless built with the roaringdebug tag, and is inlined away
+func (rb *Bitmap) assertInsert(hi uint16, pos int) {}
+
+// assertValid is a no-op unless built with the roaringdebug tag, and is inlined away
+func (c *container) assertValid(op string) {}
@@ -0,0 +1,114 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+// Options represents the tunable heuristics used to choose the representation of
+// the containers. Zero fields fall back to their default values.
+type Options struct {
+	RunDense  float64 // Arrays denser than this skip counting runs and attempt to convert (default 0.8)
+	RunSparse float64 // Arrays sparser than this are never converted to runs (default 0.1)
+	RunLength float64 // Minimum average run length for an array to convert to runs (default 2.5)
+	Interval  int     // Mutations of a container between periodic optimizations, negative disables (default 2048)
+	ArrayCap  int     // Initial capacity of the array containers created when setting values (default 64)
+	ArrayMin  int     // Size at or below which bitmaps are converted back to arrays, at most 2048 (default 2048)
+	EagerCopy bool    // Clone copies the containers right away rather than on their first write (default false)
+}
+
+// SetOptimizeInterval sets how many mutations of a container happen between periodic
+// optimizations of its representation. Setting it to 0 disables periodic optimization
+// entirely, in which case Optimize must be called explicitly.
+func (rb *Bitmap) SetOptimizeInterval(n uint16) {
+	var o Options
+	if rb.opts != nil {
+		o = *rb.opts // Options may be shared with clones, never modify them in place
+	}
+
+	o.Interval = int(n)
+	if n == 0 {
+		o.Interval = -1
+	}
+	rb.opts = &o
+}
+
+// SetCopyOnWrite sets whether Clone shares the containers and copies each of them only
+// on its first write, which is the default. Disabling it makes Clone and Snapshot copy
+// all of the containers right away, trading a costlier clone for writes which never
+// pay for a deferred copy, and so a more predictable latency.
+func (rb *Bitmap) SetCopyOnWrite(enabled bool) {
+	var o Options
+	if rb.opts != nil {
+		o = *rb.opts // Options may be shared with clones, never modify them in place
+	}
+
+	o.EagerCopy = !enabled
+	rb.opts = &o
+}
+
+// runDense returns the density above which arrays are converted to runs
+func (o *Options) runDense() float64 {
+	if o == nil || o.RunDense <= 0 {
+		return 0.8
+	}
+	return o.RunDense
+}
+
+// runSparse returns the density below which arrays are never converted to runs
+func (o *Options) runSparse() float64 {
+	if o == nil || o.RunSparse <= 0 {
+		return 0.1
+	}
+	return o.RunSparse
+}
+
+// runLength returns the minimum average run length for an array to convert to runs
+func (o *Options) runLength() float64 {
+	if o == nil || o.RunLength <= 0 {
+		return 2.5
+	}
+	return o.RunLength
+}
+
+// interval returns the number of mutations between periodic optimizations, 0 if disabled
+func (o *Options) interval() uint16 {
+	switch {
+	case o == nil || o.Interval == 0:
+		return optimizeEvery
+	case o.Interval < 0:
+		return 0
+	default:
+		return uint16(min(o.Interval, 1<<16-1))
+	}
+}
+
+// arrayCap returns the initial capacity of new array containers, at most the size
+// above which arrays are converted to bitmaps
+func (o *Options) arrayCap() int {
+	if o == nil || o.ArrayCap <= 0 {
+		return 64
+	}
+	return min(o.ArrayCap, arrMinSize)
+}
+
+// arrayMin returns the size at or below which bitmaps are converted to arrays. Setting it
+// lower than the size above which arrays are converted to bitmaps leaves a margin so that
+// a container hovering around the threshold does not keep changing representation.
+func (o *Options) arrayMin() uint32 {
+	if o == nil || o.ArrayMin <= 0 {
+		return arrMinSize
+	}
+	return uint32(min(o.ArrayMin, arrMinSize))
+}
+
+// copyOnWrite returns whether clones share the containers until they are written to
+func (o *Options) copyOnWrite() bool {
+	return o == nil || !o.EagerCopy
+}
+
+// runFriendly checks whether the given number of runs is worth converting an array
+// of the given size to, saving at least 25% space with reasonably long runs.
+func (o *Options) runFriendly(size, runs int) bool {
+	sizeAsArr := size * 2
+	sizeAsRun := runs*4 + 2 // 2 uint16 per run = 4 bytes
+	return sizeAsRun < sizeAsArr*3/4 && float64(size) >= float64(runs)*o.runLength()
+}
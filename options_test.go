@@ -0,0 +1,243 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withRuns creates a bitmap with runs of the given length separated by the given gap
+func withRuns(opts Options, length, gap, count int) *Bitmap {
+	rb := New(opts)
+	for i, v := 0, 0; i < count; i, v = i+1, v+length+gap {
+		for j := 0; j < length; j++ {
+			rb.Set(uint32(v + j))
+		}
+	}
+	rb.Optimize()
+	return rb
+}
+
+func TestOptions_MediumRuns(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		length int
+		gap    int
+		expect ctype
+	}{
+		{"runs of 10, density 0.5", Options{}, 10, 10, typeRun},
+		{"runs of 10, density 0.25", Options{}, 10, 30, typeRun},
+		{"runs of 10, density 0.1", Options{}, 10, 90, typeRun},
+		{"runs of 2", Options{}, 2, 2, typeArray},
+		{"runs of 10, too sparse", Options{RunSparse: 0.3}, 10, 30, typeArray},
+		{"runs of 10, too short", Options{RunLength: 20}, 10, 10, typeArray},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb := withRuns(tt.opts, tt.length, tt.gap, 100)
+			assert.Equal(t, 1, len(rb.containers))
+			assert.Equal(t, tt.expect, rb.containers[0].Type)
+			assert.Equal(t, tt.length*100, rb.Count())
+			assert.NoError(t, rb.Validate())
+		})
+	}
+}
+
+func TestOptions_Defaults(t *testing.T) {
+	var o *Options
+	assert.Equal(t, 0.8, o.runDense())
+	assert.Equal(t, 0.1, o.runSparse())
+	assert.Equal(t, 2.5, o.runLength())
+
+	o = &Options{RunDense: 0.9, RunSparse: 0.2, RunLength: 4}
+	assert.Equal(t, 0.9, o.runDense())
+	assert.Equal(t, 0.2, o.runSparse())
+	assert.Equal(t, 4.0, o.runLength())
+	assert.Equal(t, uint16(optimizeEvery), o.interval())
+	assert.Equal(t, uint16(0), (&Options{Interval: -1}).interval())
+	assert.Equal(t, uint16(1<<16-1), (&Options{Interval: 1 << 20}).interval())
+	assert.Equal(t, 64, o.arrayCap())
+	assert.Equal(t, 8, (&Options{ArrayCap: 8}).arrayCap())
+	assert.Equal(t, arrMinSize, (&Options{ArrayCap: 1 << 20}).arrayCap())
+	assert.Equal(t, uint32(arrMinSize), o.arrayMin())
+	assert.Equal(t, uint32(1536), (&Options{ArrayMin: 1536}).arrayMin())
+	assert.Equal(t, uint32(arrMinSize), (&Options{ArrayMin: 1 << 20}).arrayMin())
+	assert.True(t, o.copyOnWrite())
+	assert.False(t, (&Options{EagerCopy: true}).copyOnWrite())
+}
+
+func TestOptions_ArrayCap(t *testing.T) {
+	for _, size := range []int{0, 1, 16, 1024} {
+		rb := New(Options{ArrayCap: size})
+		rb.Set(1)
+		assert.Equal(t, rb.opts.arrayCap(), cap(rb.containers[0].Data))
+
+		assert.NoError(t, rb.AppendSorted(1<<16))
+		assert.Equal(t, rb.opts.arrayCap(), cap(rb.containers[1].Data))
+	}
+}
+
+func BenchmarkOptions_ArrayCap(b *testing.B) {
+	shapes := map[string]uint32{"sparse": 1 << 14, "medium": 64, "dense": 16}
+	for name, step := range shapes {
+		for _, size := range []int{4, 64, 1024} {
+			b.Run(fmt.Sprintf("%s-%d", name, size), func(b *testing.B) {
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					rb := New(Options{ArrayCap: size})
+					for v := uint32(0); v < 1<<22; v += step {
+						rb.Set(v)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestOptions_Interval(t *testing.T) {
+	fill := func(rb *Bitmap) *Bitmap {
+		for i := uint32(0); i < 5000; i++ {
+			rb.Set(i)
+		}
+		return rb
+	}
+
+	// By default the container is periodically converted to runs
+	assert.Equal(t, typeRun, fill(New()).containers[0].Type)
+
+	// With the interval disabled, nothing is converted until an explicit optimize
+	rb := New(Options{RunLength: 20})
+	rb.SetOptimizeInterval(0)
+	fill(rb)
+	assert.Equal(t, typeArray, rb.containers[0].Type)
+	assert.Equal(t, 20.0, rb.opts.runLength())
+	for i := uint32(0); i < 5000; i += 2 {
+		rb.Remove(i)
+	}
+	assert.Equal(t, typeArray, rb.containers[0].Type)
+
+	fill(rb)
+	rb.Optimize()
+	assert.Equal(t, typeRun, rb.containers[0].Type)
+	assert.Equal(t, 5000, rb.Count())
+
+	// A shorter interval optimizes sooner, without affecting clones
+	rb = New()
+	clone := rb.Clone(nil)
+	rb.SetOptimizeInterval(256)
+	for i := uint32(0); i < 256; i++ {
+		rb.Set(i)
+	}
+	assert.Equal(t, typeRun, rb.containers[0].Type)
+	assert.Nil(t, clone.opts)
+}
+
+func TestOptions_ArrayMin(t *testing.T) {
+	oscillate := func(opts Options) (conversions int) {
+		rb := New(opts)
+		rb.SetOptimizeInterval(1)
+		for v := uint32(0); v <= arrMinSize*4; v += 4 {
+			rb.Set(v)
+		}
+
+		// Hover around the threshold, one value above and one at it
+		typ := rb.containers[0].Type
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 {
+				rb.Remove(0)
+			} else {
+				rb.Set(0)
+			}
+
+			if c := rb.containers[0]; c.Type != typ {
+				typ = c.Type
+				conversions++
+			}
+		}
+
+		assert.Equal(t, arrMinSize+1, rb.Count())
+		assert.NoError(t, rb.Validate())
+		return conversions
+	}
+
+	assert.Equal(t, 100, oscillate(Options{}))
+	assert.Equal(t, 0, oscillate(Options{ArrayMin: arrMinSize * 3 / 4}))
+
+	// Bitmaps are still converted once they shrink below the margin
+	rb := New(Options{ArrayMin: arrMinSize * 3 / 4})
+	rb.SetOptimizeInterval(1)
+	for v := uint32(0); v <= arrMinSize*4; v += 4 {
+		rb.Set(v)
+	}
+
+	assert.Equal(t, typeBitmap, rb.containers[0].Type)
+	for v := uint32(0); rb.Count() > arrMinSize*3/4; v += 4 {
+		rb.Remove(v)
+	}
+	assert.Equal(t, typeArray, rb.containers[0].Type)
+}
+
+func TestOptions_Clone(t *testing.T) {
+	rb := New(Options{RunLength: 20})
+	assert.Equal(t, rb.opts, rb.Clone(nil).opts)
+	assert.Nil(t, New().opts)
+
+	// Cloning into an existing bitmap replaces its options as well
+	into := New(Options{RunLength: 5})
+	assert.Same(t, into, rb.Clone(into))
+	assert.Equal(t, rb.opts, into.opts)
+	assert.Nil(t, New().Clone(into).opts)
+}
+
+func TestOptions_CopyOnWrite(t *testing.T) {
+	rb := makeTestBitmap()
+	rb.SetCopyOnWrite(false)
+	clone := rb.Clone(nil)
+	assert.False(t, clone.opts.copyOnWrite())
+	bitmapsEqual(t, rb, clone)
+
+	// Nothing is shared, so the first writes on either side never fork
+	for i := range rb.containers {
+		assert.False(t, rb.containers[i].Shared)
+		assert.False(t, clone.containers[i].Shared)
+		assert.NotSame(t, &rb.containers[i].Data[0], &clone.containers[i].Data[0])
+	}
+
+	data := &rb.containers[1].Data[0]
+	rb.Set(65536 + 1)
+	assert.Same(t, data, &rb.containers[1].Data[0])
+	assert.True(t, rb.Contains(65536+1))
+	assert.False(t, clone.Contains(65536+1))
+
+	// Enabling it again shares the containers of the next clones
+	rb.SetCopyOnWrite(true)
+	clone = rb.Clone(nil)
+	assert.True(t, rb.containers[1].Shared)
+	assert.Same(t, &rb.containers[1].Data[0], &clone.containers[1].Data[0])
+}
+
+func BenchmarkOptions_CopyOnWrite(b *testing.B) {
+	for _, enabled := range []bool{true, false} {
+		b.Run(fmt.Sprintf("cow-%v", enabled), func(b *testing.B) {
+			rb := makeTestBitmap()
+			rb.SetCopyOnWrite(enabled)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				clone := rb.Clone(nil)
+				b.StartTimer()
+
+				// Only the first write after the clone is measured
+				clone.Set(65536 + 1)
+			}
+		})
+	}
+}
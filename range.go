@@ -3,40 +3,211 @@
 
 package roaring
 
-// Range calls the given function for each value in the bitmap
+// Range calls the given function for each value in the bitmap. Values are always
+// visited in strictly ascending order, regardless of the container types.
 func (rb *Bitmap) Range(fn func(x uint32) bool) {
+	if rb == nil {
+		return
+	}
+
+	for i := range rb.containers {
+		if !rb.containers[i].rangeFrom(uint32(rb.index[i])<<16, fn) {
+			return
+		}
+	}
+}
+
+// RangeKeysSubset calls the given function for each value in the bitmap whose container
+// key is one of the given keys, which must be sorted in ascending order. The keys and
+// the index of the bitmap are advanced together, so other containers are never visited.
+func (rb *Bitmap) RangeKeysSubset(keys []uint16, fn func(x uint32) bool) {
+	pos := 0
+	for _, key := range keys {
+		idx, exists := find16(rb.index[pos:], key)
+		if pos += idx; pos >= len(rb.index) {
+			return
+		}
+
+		if exists && !rb.containers[pos].rangeFrom(uint32(key)<<16, fn) {
+			return
+		}
+	}
+}
+
+// RangeBuckets calls the given function once per container with its key and all of its
+// values in ascending order. The values are materialized into a single buffer reused
+// across containers, so the slice is only valid for the duration of the call.
+func (rb *Bitmap) RangeBuckets(fn func(key uint16, values []uint32) bool) {
+	if len(rb.containers) == 0 {
+		return
+	}
+
+	size := uint32(0)
+	for i := range rb.containers {
+		size = max(size, rb.containers[i].Size)
+	}
+
+	buffer := make([]uint32, 0, size)
+	for i := range rb.containers {
+		values := buffer[:0]
+		rb.containers[i].rangeFrom(uint32(rb.index[i])<<16, func(x uint32) bool {
+			values = append(values, x)
+			return true
+		})
+
+		if !fn(rb.index[i], values) {
+			return
+		}
+	}
+}
+
+// rangeFrom calls the given function for each value of the container, offset by the
+// given base. It returns false if the iteration was stopped by the function.
+func (c *container) rangeFrom(base uint32, fn func(x uint32) bool) bool {
+	switch c.Type {
+	case typeArray:
+		data := c.Data
+		for j := 0; j < len(data); j++ {
+			if !fn(base | uint32(data[j])) {
+				return false
+			}
+		}
+
+	case typeBitmap:
+		return c.bmpRange(func(value uint32) bool {
+			return fn(base | value)
+		})
+
+	case typeRun:
+		numRuns := len(c.Data) / 2
+		for i := 0; i < numRuns; i++ {
+			start, end := uint32(c.Data[i*2]), uint32(c.Data[i*2+1])
+			for curr := start; curr <= end; curr++ {
+				if !fn(base | curr) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// ToArray returns all of the values of the bitmap in ascending order
+func (rb *Bitmap) ToArray() []uint32 {
+	return rb.ToArrayInto(nil)
+}
+
+// ToArrayInto returns all of the values of the bitmap in ascending order, written over
+// the given buffer if it has enough capacity and into a newly allocated slice otherwise.
+func (rb *Bitmap) ToArrayInto(buf []uint32) []uint32 {
+	out := buf[:0]
+	if n := rb.Count(); cap(buf) < n {
+		out = make([]uint32, 0, n) // Only a capacity hint, a stale size must not overflow
+	}
+
 	for i := range rb.containers {
 		c := &rb.containers[i]
 		base := uint32(rb.index[i]) << 16
 
 		switch c.Type {
 		case typeArray:
-			data := c.Data
-			for j := 0; j < len(data); j++ {
-				if !fn(base | uint32(data[j])) {
-					return
+			for _, v := range c.Data {
+				out = append(out, base|uint32(v))
+			}
+		case typeBitmap:
+			c.bmpRange(func(v uint32) bool {
+				out = append(out, base|v)
+				return true
+			})
+		case typeRun:
+			for j := 0; j+1 < len(c.Data); j += 2 {
+				start, end := uint32(c.Data[j]), uint32(c.Data[j+1])
+				for v := start; v <= end; v++ {
+					out = append(out, base|v)
 				}
 			}
+		}
+	}
+	return out
+}
 
-		case typeBitmap:
-			if !c.bmpRange(func(value uint32) bool {
-				return fn(base | value)
-			}) {
-				return
+// ToDenseBitset returns the values in the half-open interval [start, end) as a packed
+// dense bitset, where bit i is set if and only if start+i is present in the bitmap.
+func (rb *Bitmap) ToDenseBitset(start, end uint32) []uint64 {
+	if start >= end {
+		return []uint64{}
+	}
+
+	out := make([]uint64, (uint64(end-start)+63)/64)
+	lo, hi := start, end-1
+	k0, k1 := uint16(lo>>16), uint16(hi>>16)
+	for i, _ := find16(rb.index, k0); i < len(rb.index) && rb.index[i] <= k1; i++ {
+		rb.containers[i].rangeFrom(uint32(rb.index[i])<<16, func(x uint32) bool {
+			switch {
+			case x < lo:
+				return true
+			case x > hi:
+				return false
 			}
 
-		case typeRun:
-			numRuns := len(c.Data) / 2
-			for i := 0; i < numRuns; i++ {
-				start, end := uint32(c.Data[i*2]), uint32(c.Data[i*2+1])
-				for curr := start; curr <= end; curr++ {
-					if !fn(base | curr) {
-						return
-					}
-				}
+			x -= start
+			out[x>>6] |= 1 << (x & 63)
+			return true
+		})
+	}
+	return out
+}
+
+// CollectInto replaces the contents of the destination slice with all of the values
+// of the bitmap in ascending order, growing it at most once to fit all of them.
+func (rb *Bitmap) CollectInto(dst *[]uint32) {
+	*dst = rb.ToArrayInto(*dst)
+}
+
+// RangeContainerRuns calls the given function for each container with its key and its
+// values expressed as sorted, inclusive [start, end] runs. Run containers yield their
+// data as-is while the runs of array and bitmap containers are derived on the fly. The
+// runs slice is only valid during the call and must not be modified.
+func (rb *Bitmap) RangeContainerRuns(fn func(key uint16, runs [][2]uint16) bool) {
+	var buffer [][2]uint16
+	for i := range rb.containers {
+		c := &rb.containers[i]
+
+		runs := asRuns(c.Data)
+		if c.Type != typeRun {
+			buffer = c.appendRuns(buffer[:0])
+			runs = buffer
+		}
+
+		if !fn(rb.index[i], runs) {
+			return
+		}
+	}
+}
+
+// appendRuns appends the runs of consecutive values in the container to dst
+func (c *container) appendRuns(dst [][2]uint16) [][2]uint16 {
+	switch c.Type {
+	case typeArray:
+		for i := 0; i < len(c.Data); {
+			start := c.Data[i]
+			for i++; i < len(c.Data) && c.Data[i] == c.Data[i-1]+1; i++ {
 			}
+			dst = append(dst, [2]uint16{start, c.Data[i-1]})
 		}
+	case typeBitmap:
+		c.bmpRange(func(x uint32) bool {
+			if n := len(dst); n > 0 && uint32(dst[n-1][1])+1 == x {
+				dst[n-1][1] = uint16(x)
+			} else {
+				dst = append(dst, [2]uint16{uint16(x), uint16(x)})
+			}
+			return true
+		})
+	case typeRun:
+		dst = append(dst, asRuns(c.Data)...)
 	}
+	return dst
 }
 
 // Filter iterates over the bitmap elements and calls a predicate provided for each
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/kelindar/bitmap"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -426,3 +427,334 @@ func TestRangeStop(t *testing.T) {
 
 	assert.Equal(t, 63, count)
 }
+
+func TestRangeOrder(t *testing.T) {
+	tricky := newBmp()
+	for word := 0; word < 1024; word += 7 {
+		for _, bit := range []int{0, 1, 3, 4, 5, 60, 63} {
+			if (word+bit)%3 != 0 {
+				tricky.bmpSet(uint16(word*64 + bit))
+			}
+		}
+	}
+
+	rb := New()
+	rb.ctrAdd(0, 0, newBmpPermutations())
+	rb.ctrAdd(1, 1, tricky)
+	rb.ctrAdd(2, 2, newArr(0, 1, 3, 65535))
+	rb.ctrAdd(3, 3, newRun(0, 1, 3, 4, 5, 65535))
+
+	var values []uint32
+	rb.Range(func(x uint32) bool {
+		if n := len(values); n > 0 {
+			assert.Less(t, values[n-1], x)
+		}
+		values = append(values, x)
+		return true
+	})
+
+	assert.Equal(t, rb.Count(), len(values))
+	assert.True(t, sort.SliceIsSorted(values, func(i, j int) bool {
+		return values[i] < values[j]
+	}))
+}
+
+func TestRangeContainerRuns(t *testing.T) {
+	tests := []struct {
+		name string
+		cnr  *container
+		runs [][2]uint16
+	}{
+		{"arr", newArr(1, 2, 3, 5, 7, 8, 65535), [][2]uint16{{1, 3}, {5, 5}, {7, 8}, {65535, 65535}}},
+		{"bmp", newBmp(0, 1, 2, 3, 63, 64, 65, 1000), [][2]uint16{{0, 3}, {63, 65}, {1000, 1000}}},
+		{"run", newRun(0, 1, 2, 10, 11, 65534, 65535), [][2]uint16{{0, 2}, {10, 11}, {65534, 65535}}},
+		{"bmp permutations", newBmpPermutations(), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb, values := bitmapWith(tt.cnr)
+
+			var out []uint16
+			rb.RangeContainerRuns(func(key uint16, runs [][2]uint16) bool {
+				assert.Equal(t, uint16(0), key)
+				if tt.runs != nil {
+					assert.Equal(t, tt.runs, runs)
+				}
+
+				for _, r := range runs {
+					for v := uint32(r[0]); v <= uint32(r[1]); v++ {
+						out = append(out, uint16(v))
+					}
+				}
+				return true
+			})
+
+			assert.Equal(t, values, out)
+		})
+	}
+
+	t.Run("multiple containers", func(t *testing.T) {
+		rb := makeTestBitmap()
+
+		var keys []uint16
+		var out []uint32
+		rb.RangeContainerRuns(func(key uint16, runs [][2]uint16) bool {
+			keys = append(keys, key)
+			for _, r := range runs {
+				for v := uint32(r[0]); v <= uint32(r[1]); v++ {
+					out = append(out, uint32(key)<<16|v)
+				}
+			}
+			return true
+		})
+
+		var expect []uint32
+		rb.Range(func(x uint32) bool { expect = append(expect, x); return true })
+		assert.Equal(t, rb.index, keys)
+		assert.Equal(t, expect, out)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		count := 0
+		makeTestBitmap().RangeContainerRuns(func(uint16, [][2]uint16) bool {
+			count++
+			return false
+		})
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestCollectInto(t *testing.T) {
+	for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+		rb, values := changeType(typ)
+		assert.Equal(t, values, rb.ToArray())
+	}
+
+	rb := makeTestBitmap()
+	var expect []uint32
+	rb.Range(func(x uint32) bool { expect = append(expect, x); return true })
+	assert.Equal(t, expect, rb.ToArray())
+
+	// Reuses the capacity and replaces the previous contents
+	dst := make([]uint32, 5, len(expect)+10)
+	rb.CollectInto(&dst)
+	assert.Equal(t, expect, dst)
+	assert.Equal(t, len(expect)+10, cap(dst))
+
+	// Empty bitmap clears the destination
+	New().CollectInto(&dst)
+	assert.Empty(t, dst)
+	assert.Empty(t, New().ToArray())
+}
+
+func TestToArray(t *testing.T) {
+	gens := []dataGen{genSeq(1000, 0), genRand(10000, 1<<20), genSparse(1000), genDense(10000), genBoundary(), genMixed()}
+	for _, gen := range gens {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			for _, typ := range []ContainerType{ContainerArray, ContainerBitmap, ContainerRun} {
+				rb := NewForTest(typ, data...)
+				var expect []uint32
+				rb.Range(func(x uint32) bool { expect = append(expect, x); return true })
+
+				out := rb.ToArray()
+				assert.Equal(t, expect, out, typ.String())
+				assert.Equal(t, len(out), cap(out))
+
+				// The buffer is reused when large enough and replaced otherwise
+				buf := make([]uint32, 3, len(expect)+1)
+				assert.Equal(t, expect, rb.ToArrayInto(buf), typ.String())
+				assert.Equal(t, expect, buf[:len(expect)])
+				assert.Equal(t, expect, rb.ToArrayInto(make([]uint32, 1)), typ.String())
+			}
+		})
+	}
+
+	assert.Empty(t, New().ToArrayInto(make([]uint32, 5)))
+
+	// A stale container size only affects the capacity of the result
+	for _, typ := range []ContainerType{ContainerArray, ContainerBitmap, ContainerRun} {
+		rb := NewForTest(typ, seq(0, 1000, 3)...)
+		expect := rb.ToArray()
+		for _, size := range []uint32{1, 5000} {
+			rb.containers[0].Size = size
+			assert.Equal(t, expect, rb.ToArray(), typ.String())
+		}
+	}
+}
+
+func TestRangeKeysSubset(t *testing.T) {
+	rb := makeTestBitmap()
+	rb.AddRange(5<<16, 9<<16)
+
+	for _, keys := range [][]uint16{
+		nil,
+		{0},
+		{0, 1, 2, 65535},
+		{1, 3, 4, 6, 8},
+		{2, 10, 100},
+		{65535},
+		{9, 10, 65534},
+	} {
+		member := make(map[uint16]bool)
+		for _, k := range keys {
+			member[k] = true
+		}
+
+		var expect, actual []uint32
+		rb.Range(func(x uint32) bool {
+			if member[uint16(x>>16)] {
+				expect = append(expect, x)
+			}
+			return true
+		})
+
+		rb.RangeKeysSubset(keys, func(x uint32) bool {
+			actual = append(actual, x)
+			return true
+		})
+		assert.Equal(t, expect, actual, "keys %v", keys)
+	}
+
+	// Stops as soon as the function returns false
+	count := 0
+	rb.RangeKeysSubset([]uint16{1, 2}, func(x uint32) bool {
+		count++
+		return count < 10
+	})
+	assert.Equal(t, 10, count)
+}
+
+func TestRangeBuckets(t *testing.T) {
+	rb := makeTestBitmap()
+	rb.AddRange(5<<16, 9<<16)
+
+	var keys []uint16
+	var values []uint32
+	rb.RangeBuckets(func(key uint16, bucket []uint32) bool {
+		keys = append(keys, key)
+		for _, v := range bucket {
+			assert.Equal(t, key, uint16(v>>16))
+		}
+
+		values = append(values, bucket...)
+		return true
+	})
+
+	assert.Equal(t, rb.index, keys)
+	assert.Equal(t, rb.ToArray(), values)
+
+	// Stops as soon as the function returns false
+	count := 0
+	rb.RangeBuckets(func(key uint16, bucket []uint32) bool {
+		count++
+		return key < 5
+	})
+	assert.Equal(t, 4, count)
+
+	// A single buffer is reused across all of the buckets
+	assert.Equal(t, 1.0, testing.AllocsPerRun(10, func() {
+		rb.RangeBuckets(func(uint16, []uint32) bool { return true })
+	}))
+
+	New().RangeBuckets(func(uint16, []uint32) bool {
+		t.Fatal("no buckets expected")
+		return true
+	})
+}
+
+func TestToDenseBitset(t *testing.T) {
+	rb := makeTestBitmap()
+	rb.AddRange(3<<16-10, 3<<16+10)
+
+	for _, r := range [][2]uint32{
+		{0, 1},
+		{0, 64},
+		{1, 11},
+		{5, 131072 + 2000},
+		{65530, 65600},
+		{3<<16 - 20, 3<<16 + 20},
+		{4294967000, 4294967295},
+		{100, 100},
+	} {
+		start, end := r[0], r[1]
+		dense := rb.ToDenseBitset(start, end)
+		assert.Equal(t, int(end-start+63)/64, len(dense))
+
+		// Round-trip back through the dense form
+		out := New()
+		bitmap.Bitmap(dense).Range(func(x uint32) {
+			out.Set(start + x)
+		})
+
+		expect := rb.Clone(nil)
+		expect.And(FromArray(seq(int(start), int(end), 1)))
+		assert.Equal(t, expect.ToArray(), out.ToArray(), "range [%d, %d)", start, end)
+	}
+}
+
+func TestFromDenseBitset(t *testing.T) {
+	rb := makeTestBitmap()
+	rb.AddRange(3<<16-10, 3<<16+10)
+	rb.AddRange(5<<16, 7<<16)
+
+	for _, r := range [][2]uint32{
+		{0, 8 << 16},
+		{1 << 16, 3<<16 + 5},
+		{7, 131072 + 2000},
+		{65530, 65600},
+		{3<<16 - 20, 3<<16 + 20},
+		{4294967000, 4294967295},
+	} {
+		start, end := r[0], r[1]
+		expect := rb.Clone(nil)
+		expect.And(FromArray(seq(int(start), int(end), 1)))
+
+		out := FromDenseBitset(rb.ToDenseBitset(start, end), start)
+		assert.NoError(t, out.Validate())
+		assert.Equal(t, expect.Count(), out.Count())
+		assert.Equal(t, expect.ToArray(), out.ToArray(), "range [%d, %d)", start, end)
+	}
+
+	// Full containers become runs and bits beyond the 32-bit range are dropped
+	full := make([]uint64, 2048)
+	for i := range full {
+		full[i] = ^uint64(0)
+	}
+
+	out := FromDenseBitset(full, 65535<<16)
+	assert.Equal(t, 65536, out.Count())
+	assert.Equal(t, typeRun, out.containers[0].Type)
+
+	out = FromDenseBitset(full, 65535<<16+1)
+	assert.Equal(t, 65535, out.Count())
+	assert.Equal(t, 0, FromDenseBitset(nil, 0).Count())
+}
+
+func BenchmarkCollectInto(b *testing.B) {
+	data, _ := genRand(1e6, 1e7)()
+	rb, _ := testPair(data)
+	rb.Optimize()
+
+	b.Run("range-append", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var out []uint32
+			rb.Range(func(x uint32) bool {
+				out = append(out, x)
+				return true
+			})
+		}
+	})
+
+	b.Run("collect-into", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var out []uint32
+			rb.CollectInto(&out)
+		}
+	})
+}
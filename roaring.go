@@ -3,16 +3,157 @@
 
 package roaring
 
-// Bitmap represents a roaring bitmap for uint32 values
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+
+	"github.com/kelindar/bitmap"
+)
+
+// ErrUnsorted is returned when values are appended out of order
+var ErrUnsorted = errors.New("roaring: value is out of order")
+
+// Bitmap represents a roaring bitmap for uint32 values. A nil bitmap reads as empty in
+// Contains, Count, IsEmpty, Range, Min and Max, but mutating it panics.
 type Bitmap struct {
 	containers []container // Containers in sorted order by key
 	index      []uint16    // Container keys for cache-efficient searching
 	scratch    []uint16
+	opts       *Options // Optional tuning of the heuristics, nil for defaults
 }
 
-// New creates a new empty roaring bitmap
-func New() *Bitmap {
-	return &Bitmap{}
+// New creates a new empty roaring bitmap, optionally tuned with the given options
+func New(opts ...Options) *Bitmap {
+	rb := &Bitmap{}
+	if len(opts) > 0 {
+		o := opts[0]
+		rb.opts = &o
+	}
+	return rb
+}
+
+// FromArray creates a new roaring bitmap from a slice of values
+func FromArray(values []uint32) *Bitmap {
+	rb := New()
+	rb.OrValues(values)
+	return rb
+}
+
+// Of creates a new roaring bitmap holding the given values, which may be unsorted and
+// contain duplicates, built in bulk just like SetMany.
+func Of(values ...uint32) *Bitmap {
+	rb := New()
+	rb.SetMany(values)
+	return rb
+}
+
+// FromRange creates a new roaring bitmap holding all of the values in the half-open
+// interval [lo, hi). Every container is built directly as a single run, and their data
+// is carved out of a single allocation.
+func FromRange(lo, hi uint32) *Bitmap {
+	rb := New()
+	if lo >= hi {
+		return rb
+	}
+
+	k0, k1 := lo>>16, (hi-1)>>16
+	n := int(k1-k0) + 1
+	data := make([]uint16, 2*n)
+	rb.containers = make([]container, n)
+	rb.index = make([]uint16, n)
+	for i := range rb.containers {
+		start, end := uint16(0), uint16(0xFFFF)
+		if i == 0 {
+			start = uint16(lo & 0xFFFF)
+		}
+		if i == n-1 {
+			end = uint16((hi - 1) & 0xFFFF)
+		}
+
+		run := data[2*i : 2*i+2 : 2*i+2]
+		run[0], run[1] = start, end
+		rb.containers[i] = container{Type: typeRun, Size: uint32(end-start) + 1, Data: run}
+		rb.index[i] = uint16(k0) + uint16(i)
+	}
+
+	rb.assertValid("FromRange")
+	return rb
+}
+
+// FromMap creates a new roaring bitmap from the keys of a set-like map. The keys are
+// sorted once and built in bulk, which is faster than setting them one by one.
+func FromMap(m map[uint32]struct{}) *Bitmap {
+	values := make([]uint32, 0, len(m))
+	for v := range m {
+		values = append(values, v)
+	}
+
+	slices.Sort(values)
+	rb := New()
+	rb.OrValues(values)
+	return rb
+}
+
+// NewForTest creates a new roaring bitmap holding the values, with every container stored
+// in the given representation even where the heuristics would pick another one. It is
+// meant for tests which exercise a specific representation, so periodic optimization
+// is disabled to keep it until Optimize is called. ContainerNone keeps the heuristics.
+func NewForTest(typ ContainerType, values ...uint32) *Bitmap {
+	rb := FromArray(values)
+	rb.SetOptimizeInterval(0)
+	for i := range rb.containers {
+		switch typ {
+		case ContainerArray:
+			rb.containers[i].convert(typeArray)
+		case ContainerBitmap:
+			rb.containers[i].convert(typeBitmap)
+		case ContainerRun:
+			rb.containers[i].convert(typeRun)
+		}
+	}
+	return rb
+}
+
+// FromDenseBitset creates a new roaring bitmap from a dense bitset, where bit i of the
+// words maps to the value offset+i. When the offset is aligned to a container, every 1024
+// words are copied into a container at once. Bits beyond the 32-bit range are ignored.
+func FromDenseBitset(words []uint64, offset uint32) *Bitmap {
+	rb := New()
+	if offset&0xFFFF == 0 {
+		for i := 0; i < len(words); i += 1024 {
+			key := uint64(offset>>16) + uint64(i/1024)
+			if key > 0xFFFF {
+				break
+			}
+
+			c := container{Type: typeBitmap, Data: make([]uint16, bitmapSize)}
+			copy(c.bmp(), words[i:min(i+1024, len(words))])
+			if c.Size = uint32(c.bmp().Count()); c.Size > 0 {
+				c.optimize(rb.opts)
+				rb.ctrAdd(uint16(key), len(rb.containers), &c)
+			}
+		}
+		return rb
+	}
+
+	// Unaligned offsets shift every value, so append them one by one in order
+	for i, w := range words {
+		for ; w != 0; w &= w - 1 {
+			v := uint64(offset) + uint64(i)*64 + uint64(bits.TrailingZeros64(w))
+			if v > math.MaxUint32 {
+				break
+			}
+			rb.AppendSorted(uint32(v))
+		}
+	}
+
+	if n := len(rb.containers); n > 0 {
+		rb.containers[n-1].optimize(rb.opts)
+	}
+	return rb
 }
 
 // Set sets the bit x in the bitmap and grows it if necessary.
@@ -23,17 +164,70 @@ func (rb *Bitmap) Set(x uint32) {
 		rb.ctrAdd(hi, idx, &container{
 			Type: typeArray,
 			Size: 0,
-			Data: make([]uint16, 0, 64),
+			Data: make([]uint16, 0, rb.opts.arrayCap()),
 		})
 	}
-	rb.containers[idx].set(lo)
+	rb.containers[idx].set(lo, rb.opts)
+}
+
+// SetMany sets all of the values, in any order. The values are grouped by container, so
+// that each container is located and merged into once rather than once per value. The
+// slice is left untouched, and is copied to be sorted if it is not sorted already.
+func (rb *Bitmap) SetMany(values []uint32) {
+	rb.OrValues(values)
+}
+
+// SetManySorted sets all of the values, which are expected to be sorted in ascending
+// order. It is an alias of OrValues, which sorts a copy of the values otherwise.
+func (rb *Bitmap) SetManySorted(values []uint32) {
+	rb.OrValues(values)
+}
+
+// AppendSorted sets the bit x, assuming it is greater than or equal to the largest value
+// of the bitmap. This is cheaper than Set since the value is appended to the last
+// container without searching, and returns ErrUnsorted if the assumption is violated.
+// The last container is optimized once values move on to the next one, or on Optimize.
+func (rb *Bitmap) AppendSorted(x uint32) error {
+	hi, lo := uint16(x>>16), uint16(x&0xFFFF)
+	n := len(rb.containers)
+	switch {
+	case n == 0 || rb.index[n-1] < hi:
+		if n > 0 {
+			rb.containers[n-1].optimize(rb.opts)
+		}
+
+		rb.ctrAdd(hi, n, &container{
+			Type: typeArray,
+			Size: 1,
+			Data: append(make([]uint16, 0, rb.opts.arrayCap()), lo),
+		})
+		return nil
+	case rb.index[n-1] > hi:
+		return fmt.Errorf("%w: %d is below the container %d", ErrUnsorted, x, rb.index[n-1])
+	}
+
+	c := &rb.containers[n-1]
+	max, _ := c.max()
+	switch {
+	case lo < max:
+		return fmt.Errorf("%w: %d is below %d", ErrUnsorted, x, uint32(hi)<<16|uint32(max))
+	case lo == max:
+		return nil
+	case c.Type == typeArray:
+		c.fork()
+		c.Data = append(c.Data, lo)
+		c.Size++
+	default:
+		c.set(lo, rb.opts)
+	}
+	return nil
 }
 
 // Remove removes the bit x from the bitmap
 func (rb *Bitmap) Remove(x uint32) {
 	hi, lo := uint16(x>>16), uint16(x&0xFFFF)
 	idx, exists := find16(rb.index, hi)
-	if !exists || !rb.containers[idx].remove(lo) {
+	if !exists || !rb.containers[idx].remove(lo, rb.opts) {
 		return
 	}
 
@@ -42,9 +236,204 @@ func (rb *Bitmap) Remove(x uint32) {
 	}
 }
 
+// RemoveMany removes all of the values, in any order. The values are grouped by container,
+// so that each container is located once and deleted at most once if it becomes empty.
+// The slice is left untouched, and is copied to be sorted if it is not sorted already.
+func (rb *Bitmap) RemoveMany(values []uint32) {
+	rb.AndNotValues(values)
+}
+
+// Flip toggles the bit x, removing it if it is present and setting it otherwise. Unlike
+// checking Contains before calling Set or Remove, the index is only searched once and so
+// is the container when the bit is present, since the removal reports whether it was.
+func (rb *Bitmap) Flip(x uint32) {
+	hi, lo := uint16(x>>16), uint16(x&0xFFFF)
+	idx, exists := find16(rb.index, hi)
+	switch {
+	case !exists:
+		rb.ctrAdd(hi, idx, &container{
+			Type: typeArray,
+			Size: 0,
+			Data: make([]uint16, 0, rb.opts.arrayCap()),
+		})
+		rb.containers[idx].set(lo, rb.opts)
+	case !rb.containers[idx].remove(lo, rb.opts):
+		rb.containers[idx].set(lo, rb.opts) // Absent, so it was not removed
+	case rb.containers[idx].isEmpty():
+		rb.ctrDel(idx)
+	}
+}
+
+// AddRange sets all of the values in the half-open interval [lo, hi)
+func (rb *Bitmap) AddRange(lo, hi uint32) {
+	if lo >= hi {
+		return
+	}
+
+	rb.addRange(lo, hi-1)
+}
+
+// AddRangeClosed sets all of the values in the closed interval [lo, hi], which allows
+// the range to include the maximum value of 4294967295.
+func (rb *Bitmap) AddRangeClosed(lo, hi uint32) {
+	if lo > hi {
+		return
+	}
+
+	rb.addRange(lo, hi)
+}
+
+// SetRange sets all of the values in the half-open interval [lo, hi), it is an alias
+// of AddRange.
+func (rb *Bitmap) SetRange(lo, hi uint32) {
+	rb.AddRange(lo, hi)
+}
+
+// RemoveRange removes all of the values in the half-open interval [lo, hi)
+func (rb *Bitmap) RemoveRange(lo, hi uint32) {
+	if lo >= hi {
+		return
+	}
+
+	rb.removeRange(lo, hi-1)
+}
+
+// RemoveRangeClosed removes all of the values in the closed interval [lo, hi], which
+// allows the range to include the maximum value of 4294967295.
+func (rb *Bitmap) RemoveRangeClosed(lo, hi uint32) {
+	if lo > hi {
+		return
+	}
+
+	rb.removeRange(lo, hi)
+}
+
+// ClearRange removes all of the values in the half-open interval [lo, hi), it is an
+// alias of RemoveRange.
+func (rb *Bitmap) ClearRange(lo, hi uint32) {
+	rb.RemoveRange(lo, hi)
+}
+
+// FlipRange toggles all of the values in the half-open interval [lo, hi), so that the
+// values present are removed and the values absent are added.
+func (rb *Bitmap) FlipRange(lo, hi uint32) {
+	if lo >= hi {
+		return
+	}
+
+	rb.flipRange(lo, hi-1)
+	rb.assertValid("FlipRange")
+}
+
+// SetAllExcept replaces the container with the given key by one holding all of its 65536
+// values except the ones in the given dense bucket, where each bit of the bucket is a
+// low 16-bit value. This is much cheaper than setting the values one by one when only a
+// few of them are absent. Bits beyond the container range are ignored.
+func (rb *Bitmap) SetAllExcept(key uint16, absent bitmap.Bitmap) {
+	c := container{Type: typeBitmap, Data: make([]uint16, bitmapSize)}
+	full := c.bmp()
+	for i := range full {
+		full[i] = ^uint64(0)
+	}
+
+	// Clear the absent values from the full container, counting what remains
+	if len(absent) >= len(full) {
+		c.Size = uint32(bmpAndNotCount(full, absent))
+	} else {
+		c.Size = uint32(bmpAndNotCount(full[:len(absent)], absent)) + uint32(len(full)-len(absent))*64
+	}
+
+	idx, exists := find16(rb.index, key)
+	switch {
+	case c.Size == 0 && exists:
+		rb.ctrDel(idx)
+	case c.Size == 0:
+		return
+	case exists:
+		c.optimize(rb.opts)
+		rb.containers[idx] = c
+	default:
+		c.optimize(rb.opts)
+		rb.ctrAdd(key, idx, &c)
+	}
+
+	rb.assertValid("SetAllExcept")
+}
+
+// addRange sets all of the values in the closed interval [lo, hi]
+func (rb *Bitmap) addRange(lo, hi uint32) {
+	k0, k1 := lo>>16, hi>>16
+	for key := k0; key <= k1; key++ {
+		start, end := uint16(0), uint16(0xFFFF)
+		if key == k0 {
+			start = uint16(lo & 0xFFFF)
+		}
+		if key == k1 {
+			end = uint16(hi & 0xFFFF)
+		}
+
+		rb.ctrAddRange(uint16(key), start, end)
+	}
+}
+
+// removeRange removes all of the values in the closed interval [lo, hi], compacting
+// the containers which became empty.
+func (rb *Bitmap) removeRange(lo, hi uint32) {
+	k0, k1 := uint16(lo>>16), uint16(hi>>16)
+	i, _ := find16(rb.index, k0)
+	n := i
+	for ; i < len(rb.index) && rb.index[i] <= k1; i++ {
+		key := rb.index[i]
+		start, end := uint16(0), uint16(0xFFFF)
+		if key == k0 {
+			start = uint16(lo & 0xFFFF)
+		}
+		if key == k1 {
+			end = uint16(hi & 0xFFFF)
+		}
+
+		if rb.ctrRemoveRange(&rb.containers[i], start, end) {
+			rb.containers[n] = rb.containers[i]
+			rb.index[n] = key
+			n++
+		}
+	}
+
+	// Shift the containers after the range into place
+	copy(rb.containers[n:], rb.containers[i:])
+	copy(rb.index[n:], rb.index[i:])
+	rb.containers = rb.containers[:n+len(rb.containers)-i]
+	rb.index = rb.index[:n+len(rb.index)-i]
+}
+
+// flipRange toggles all of the values in the closed interval [lo, hi], creating the
+// containers which are missing and removing the ones which became empty.
+func (rb *Bitmap) flipRange(lo, hi uint32) {
+	k0, k1 := lo>>16, hi>>16
+	for key := k0; key <= k1; key++ {
+		start, end := uint16(0), uint16(0xFFFF)
+		if key == k0 {
+			start = uint16(lo & 0xFFFF)
+		}
+		if key == k1 {
+			end = uint16(hi & 0xFFFF)
+		}
+
+		rb.ctrFlipRange(uint16(key), start, end)
+	}
+}
+
 // Contains checks whether a value is contained in the bitmap
 func (rb *Bitmap) Contains(x uint32) bool {
+	if rb == nil {
+		return false
+	}
+
 	hi, lo := uint16(x>>16), uint16(x&0xFFFF)
+	if len(rb.index) == 1 {
+		return rb.index[0] == hi && rb.containers[0].contains(lo)
+	}
+
 	idx, exists := find16(rb.index, hi)
 	if !exists {
 		return false
@@ -53,8 +442,73 @@ func (rb *Bitmap) Contains(x uint32) bool {
 	return rb.containers[idx].contains(lo)
 }
 
+// Probe checks whether the bitmap contains the value x, just like Contains, and also
+// returns the type of the container holding its key, or ContainerNone if there is none.
+// This lets callers decide how to probe further values of the same container.
+func (rb *Bitmap) Probe(x uint32) (found bool, typ ContainerType) {
+	hi, lo := uint16(x>>16), uint16(x&0xFFFF)
+	idx, exists := find16(rb.index, hi)
+	if !exists {
+		return false, ContainerNone
+	}
+
+	c := &rb.containers[idx]
+	switch c.Type {
+	case typeArray:
+		typ = ContainerArray
+	case typeBitmap:
+		typ = ContainerBitmap
+	case typeRun:
+		typ = ContainerRun
+	}
+	return c.contains(lo), typ
+}
+
+// PinType converts the container holding the key to the given representation and pins
+// it there, exempting it from the periodic and post-operation optimizations as well as
+// from Optimize. Operations whose kernels change the representation, such as a bitmap
+// AND an array, convert the result back to the pinned one. ContainerNone unpins it and
+// lets the heuristics pick again. The pin is
+// not serialized, and is lost along with the container once it becomes empty. Nothing
+// happens if no container holds the key.
+func (rb *Bitmap) PinType(key uint16, typ ContainerType) {
+	idx, exists := find16(rb.index, key)
+	if !exists {
+		return
+	}
+
+	c := &rb.containers[idx]
+	switch typ {
+	case ContainerArray:
+		c.convert(typeArray)
+	case ContainerBitmap:
+		c.convert(typeBitmap)
+	case ContainerRun:
+		c.convert(typeRun)
+	}
+
+	c.Pinned = typ != ContainerNone
+	c.assertValid("PinType")
+}
+
+// BitmapWords returns the 1024 words of the bitmap container with the given key, or false
+// if there is no such container or it is not a bitmap. The words alias the internal
+// storage of the container and must not be mutated.
+func (rb *Bitmap) BitmapWords(key uint16) ([]uint64, bool) {
+	idx, exists := find16(rb.index, key)
+	if !exists || rb.containers[idx].Type != typeBitmap {
+		return nil, false
+	}
+
+	return rb.containers[idx].bmp(), true
+}
+
 // Count returns the total number of bits set to 1 in the bitmap
 func (rb *Bitmap) Count() int {
+	if rb == nil {
+		return 0
+	}
+
 	count := 0
 	for i := range rb.containers {
 		count += int(rb.containers[i].Size)
@@ -62,6 +516,22 @@ func (rb *Bitmap) Count() int {
 	return count
 }
 
+// IsEmpty checks whether the bitmap has no values at all. Operations never leave empty
+// containers behind, so this does not need to count the values and only looks past the
+// first container if a stray empty one was left by a malformed input.
+func (rb *Bitmap) IsEmpty() bool {
+	if rb == nil {
+		return true
+	}
+
+	for i := range rb.containers {
+		if rb.containers[i].Size > 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // Clear clears the bitmap
 func (rb *Bitmap) Clear() {
 	rb.containers = rb.containers[:0]
@@ -71,25 +541,65 @@ func (rb *Bitmap) Clear() {
 // Optimize optimizes all containers to use the most efficient representation
 func (rb *Bitmap) Optimize() {
 	for i := range rb.containers {
-		rb.containers[i].optimize()
+		rb.containers[i].optimize(rb.opts)
+	}
+}
+
+// Canonicalize converts every container to a representation which depends only on its
+// values, choosing whichever of run, array or bitmap encodes it in the fewest bytes. Two
+// equal bitmaps are then always serialized identically, byte for byte.
+func (rb *Bitmap) Canonicalize() {
+	for i := range rb.containers {
+		rb.containers[i].canonicalize()
+	}
+}
+
+// Arrayify converts all of the containers overlapping the half-open interval [start, end)
+// into arrays, which gives a uniform iteration cost at the expense of memory.
+func (rb *Bitmap) Arrayify(start, end uint32) {
+	if start >= end {
+		return
+	}
+
+	k0, k1 := uint16(start>>16), uint16((end-1)>>16)
+	for i, _ := find16(rb.index, k0); i < len(rb.index) && rb.index[i] <= k1; i++ {
+		switch c := &rb.containers[i]; c.Type {
+		case typeBitmap:
+			c.bmpToArr()
+			c.Shared = false // Converted into freshly allocated data
+		case typeRun:
+			c.runToArray()
+			c.Shared = false // Converted into freshly allocated data
+		}
 	}
 }
 
 // Clone clones the bitmap
 func (rb *Bitmap) Clone(into *Bitmap) *Bitmap {
 	if into == nil {
-		into = New()
+		into = &Bitmap{}
 	}
 
+	// Clone options, which are never modified in place and can be shared
+	into.opts = rb.opts
+
 	// Clone containers
 	if cap(into.containers) < len(rb.containers) {
 		into.containers = make([]container, len(rb.containers), cap(rb.containers))
 	}
 	into.containers = into.containers[:len(rb.containers)]
 	for i := range rb.containers {
-		rb.containers[i].Shared = true
+		if rb.opts.copyOnWrite() {
+			into.containers[i] = rb.containers[i].share()
+			continue
+		}
+
+		// Copy eagerly, so that neither bitmap forks the container on its next write
+		c := rb.containers[i]
+		c.Data = append(make([]uint16, 0, cap(c.Data)), c.Data...)
+		c.Shared = false
+		into.containers[i] = c
 	}
-	copy(into.containers, rb.containers)
 
 	// Clone index
 	if cap(into.index) < len(rb.index) {
@@ -101,7 +611,34 @@ func (rb *Bitmap) Clone(into *Bitmap) *Bitmap {
 	return into
 }
 
-// And performs bitwise AND operation with other bitmap(s)
+// Snapshot returns a cheap copy-on-write snapshot of the bitmap, which shares all of its
+// containers with the original. The snapshot remains stable and can be read from another
+// goroutine while the original keeps being mutated, since the original forks a shared
+// container before modifying it. The snapshot itself must not be mutated concurrently.
+func (rb *Bitmap) Snapshot() *Bitmap {
+	return rb.Clone(nil)
+}
+
+// CloneTrimmed deep-copies the bitmap into exactly sized storage, without sharing any
+// data with the original. This suits long-lived snapshots where memory matters more
+// than room for future growth.
+func (rb *Bitmap) CloneTrimmed() *Bitmap {
+	out := &Bitmap{
+		containers: make([]container, len(rb.containers)),
+		index:      append(make([]uint16, 0, len(rb.index)), rb.index...),
+		opts:       rb.opts,
+	}
+
+	for i, c := range rb.containers {
+		c.Shared = false
+		c.Data = append(make([]uint16, 0, len(c.Data)), c.Data...)
+		out.containers[i] = c
+	}
+	return out
+}
+
+// And performs bitwise AND operation with other bitmap(s). It never allocates when
+// either the bitmap or the operand is empty.
 func (rb *Bitmap) And(other *Bitmap, extra ...*Bitmap) {
 	rb.and(other)
 	for _, bm := range extra {
@@ -109,9 +646,12 @@ func (rb *Bitmap) And(other *Bitmap, extra ...*Bitmap) {
 			rb.and(bm)
 		}
 	}
+
+	rb.assertValid("And")
 }
 
-// AndNot performs bitwise AND NOT operation with other bitmap(s)
+// AndNot performs bitwise AND NOT operation with other bitmap(s). It never allocates
+// when either the bitmap or the operand is empty.
 func (rb *Bitmap) AndNot(other *Bitmap, extra ...*Bitmap) {
 	rb.andNot(other)
 	for _, bm := range extra {
@@ -119,19 +659,34 @@ func (rb *Bitmap) AndNot(other *Bitmap, extra ...*Bitmap) {
 			rb.andNot(bm)
 		}
 	}
+
+	rb.assertValid("AndNot")
+}
+
+// RemoveAll removes every value of the other bitmap from this one, which is the set
+// difference also available as AndNot. The cost scales with the size of this bitmap.
+func (rb *Bitmap) RemoveAll(other *Bitmap) {
+	rb.andNot(other)
+	rb.assertValid("RemoveAll")
 }
 
-// Or performs bitwise OR operation with other bitmap(s)
+// Or performs bitwise OR operation with other bitmap(s). It never allocates when the
+// operand is empty, and an empty bitmap only allocates if it lacks the capacity to
+// hold the containers of the operand, which are shared rather than copied.
 func (rb *Bitmap) Or(other *Bitmap, extra ...*Bitmap) {
-	rb.or(other)
+	rb.or(other, nil)
 	for _, bm := range extra {
 		if bm != nil {
-			rb.or(bm)
+			rb.or(bm, nil)
 		}
 	}
+
+	rb.assertValid("Or")
 }
 
-// Xor performs bitwise XOR operation with other bitmap(s)
+// Xor performs bitwise XOR operation with other bitmap(s). It never allocates when the
+// operand is empty, and an empty bitmap only allocates if it lacks the capacity to
+// hold the containers of the operand, which are shared rather than copied.
 func (rb *Bitmap) Xor(other *Bitmap, extra ...*Bitmap) {
 	rb.xor(other)
 	for _, bm := range extra {
@@ -139,10 +694,16 @@ func (rb *Bitmap) Xor(other *Bitmap, extra ...*Bitmap) {
 			rb.xor(bm)
 		}
 	}
+
+	rb.assertValid("Xor")
 }
 
 // Min get the smallest value stored in this bitmap, assuming the bitmap is not empty.
 func (rb *Bitmap) Min() (uint32, bool) {
+	if rb == nil {
+		return 0, false
+	}
+
 	for i := 0; i < len(rb.containers); i++ {
 		if min, ok := rb.containers[i].min(); ok {
 			return uint32(rb.index[i])<<16 | uint32(min), true
@@ -153,6 +714,10 @@ func (rb *Bitmap) Min() (uint32, bool) {
 
 // Max get the largest value stored in this bitmap, assuming the bitmap is not empty.
 func (rb *Bitmap) Max() (uint32, bool) {
+	if rb == nil {
+		return 0, false
+	}
+
 	for i := len(rb.containers) - 1; i >= 0; i-- {
 		if max, ok := rb.containers[i].max(); ok {
 			return uint32(rb.index[i])<<16 | uint32(max), true
@@ -161,6 +726,35 @@ func (rb *Bitmap) Max() (uint32, bool) {
 	return 0, false
 }
 
+// Bounds returns both the smallest and the largest value stored in this bitmap, or false
+// if it is empty. Containers are never left empty, so only the first and the last
+// containers are visited.
+func (rb *Bitmap) Bounds() (min, max uint32, ok bool) {
+	if min, ok = rb.Min(); !ok {
+		return 0, 0, false
+	}
+
+	max, _ = rb.Max()
+	return min, max, true
+}
+
+// Select returns the k-th smallest value in the bitmap, counting from zero, or false if
+// k is not below the count. It is the inverse of Rank, so Select(Rank(x)-1) is x when x
+// is present. Only the sizes of the containers before the value are visited.
+func (rb *Bitmap) Select(k uint32) (uint32, bool) {
+	for i := range rb.containers {
+		c := &rb.containers[i]
+		if k >= c.Size {
+			k -= c.Size
+			continue
+		}
+
+		v, ok := c.nth(k)
+		return uint32(rb.index[i])<<16 | uint32(v), ok
+	}
+	return 0, false
+}
+
 // MinZero finds the first zero bit and returns its index, assuming the bitmap is not empty.
 func (rb *Bitmap) MinZero() (uint32, bool) {
 	// Check if position 0 is unset (before first container or within first container)
@@ -200,10 +794,78 @@ func (rb *Bitmap) MinZero() (uint32, bool) {
 	return 0, false // No zero bits found
 }
 
+// MaxZero finds the last zero bit and returns its index. Every value above the last
+// container is unset, so this is 4294967295 unless the last container is full.
+func (rb *Bitmap) MaxZero() (uint32, bool) {
+	// Check if the last position is unset (after last container or within last container)
+	n := len(rb.containers)
+	if n == 0 || rb.index[n-1] < 65535 {
+		return 4294967295, true
+	}
+
+	// Check within last container
+	if maxZero, ok := rb.containers[n-1].maxZero(); ok {
+		return uint32(rb.index[n-1])<<16 | uint32(maxZero), true
+	}
+
+	// Check gaps between containers
+	for i := n - 1; i > 0; i-- {
+		currentHi := rb.index[i]
+		prevHi := rb.index[i-1]
+
+		// If there's a gap between containers
+		if prevHi < currentHi-1 {
+			return uint32(currentHi)<<16 - 1, true
+		}
+
+		// Check within the previous container
+		if maxZero, ok := rb.containers[i-1].maxZero(); ok {
+			return uint32(prevHi)<<16 | uint32(maxZero), true
+		}
+	}
+
+	// Check before first container
+	if firstHi := rb.index[0]; firstHi > 0 {
+		return uint32(firstHi)<<16 - 1, true
+	}
+
+	return 0, false // No zero bits found
+}
+
+// NextAbsentValue returns the smallest value which is at least x and is not present in
+// the bitmap. Keys without a container are entirely absent, so at most the containers
+// following the one of x that are full are visited. If every value from x onwards is
+// present, it returns 4294967295 which is then present as well.
+func (rb *Bitmap) NextAbsentValue(x uint32) uint32 {
+	hi, lo := uint16(x>>16), uint16(x&0xFFFF)
+	i, exists := find16(rb.index, hi)
+	if !exists {
+		return x
+	}
+
+	if v, ok := rb.containers[i].nextZero(lo); ok {
+		return uint32(hi)<<16 | uint32(v)
+	}
+
+	// The rest of the container is full, move on to the following keys
+	for key := uint32(hi) + 1; key <= 0xFFFF; key++ {
+		if i++; i >= len(rb.index) || uint32(rb.index[i]) != key {
+			return key << 16
+		}
+
+		if v, ok := rb.containers[i].minZero(); ok {
+			return key<<16 | uint32(v)
+		}
+	}
+	return 4294967295
+}
+
 // ---------------------------------------- Container ----------------------------------------
 
 // ctrAdd inserts a container at the given position
 func (rb *Bitmap) ctrAdd(hi uint16, pos int, c *container) {
+	rb.assertInsert(hi, pos)
+
 	// Insert new container at position to maintain order
 	rb.containers = append(rb.containers, container{})
 	if pos < len(rb.containers)-1 {
@@ -219,6 +881,74 @@ func (rb *Bitmap) ctrAdd(hi uint16, pos int, c *container) {
 	rb.index[pos] = hi
 }
 
+// ctrAddRange sets the closed interval [start, end] in the container with the given key
+func (rb *Bitmap) ctrAddRange(hi uint16, start, end uint16) {
+	size := uint32(end) - uint32(start) + 1
+	idx, exists := find16(rb.index, hi)
+	switch {
+	case !exists:
+		rb.ctrAdd(hi, idx, &container{
+			Type: typeRun,
+			Size: size,
+			Data: []uint16{start, end},
+		})
+	case size == 65536:
+		// A full container is always best represented by a single run, unless pinned
+		c := &rb.containers[idx]
+		typ := c.Type
+		c.Type, c.Size, c.Data, c.Shared = typeRun, size, []uint16{0, 0xFFFF}, false
+		c.repin(typ)
+	default:
+		c := &rb.containers[idx]
+		rb.ctrOr(c, &container{
+			Type: typeRun,
+			Size: size,
+			Data: []uint16{start, end},
+		})
+
+		// Large ranges merged into an array need a better representation
+		if c.Type == typeArray && c.Size > arrMinSize {
+			c.optimize(rb.opts)
+		}
+	}
+}
+
+// ctrRemoveRange removes the closed interval [start, end] from the container and
+// returns whether the container still has any values left.
+func (rb *Bitmap) ctrRemoveRange(c *container, start, end uint16) bool {
+	if start == 0 && end == 0xFFFF {
+		return false
+	}
+
+	return rb.ctrAndNot(c, &container{
+		Type: typeRun,
+		Size: uint32(end) - uint32(start) + 1,
+		Data: []uint16{start, end},
+	})
+}
+
+// ctrFlipRange toggles the closed interval [start, end] in the container with the given key
+func (rb *Bitmap) ctrFlipRange(hi uint16, start, end uint16) {
+	run := &container{
+		Type: typeRun,
+		Size: uint32(end) - uint32(start) + 1,
+		Data: []uint16{start, end},
+	}
+
+	idx, exists := find16(rb.index, hi)
+	switch {
+	case !exists:
+		rb.ctrAdd(hi, idx, run)
+	case !rb.ctrXor(&rb.containers[idx], run):
+		rb.ctrDel(idx)
+	default:
+		// Large ranges merged into an array need a better representation
+		if c := &rb.containers[idx]; c.Type == typeArray && c.Size > arrMinSize {
+			c.optimize(rb.opts)
+		}
+	}
+}
+
 // ctrDel removes the container at the given position
 func (rb *Bitmap) ctrDel(pos int) {
 	if pos < 0 || pos >= len(rb.containers) {
@@ -234,6 +964,16 @@ func (rb *Bitmap) ctrDel(pos int) {
 	rb.index = rb.index[:len(rb.index)-1]
 }
 
+// ctrShift moves the containers in [from, to) down to the given position, over the ones
+// dropped by a compacting pass, and returns the position following them
+func (rb *Bitmap) ctrShift(pos, from, to int) int {
+	if pos != from {
+		copy(rb.containers[pos:], rb.containers[from:to])
+		copy(rb.index[pos:], rb.index[from:to])
+	}
+	return pos + to - from
+}
+
 // find16 returns the first index whose value is ≥ target.
 // If the value equals target, found == true.
 // If not found, index is the insertion point to keep the slice sorted.
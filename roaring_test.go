@@ -4,9 +4,13 @@
 package roaring
 
 import (
+	"bytes"
 	"math/rand"
+	"slices"
+	"sync"
 	"testing"
 
+	"github.com/kelindar/bitmap"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -155,6 +159,235 @@ func TestTransitions(t *testing.T) {
 	})
 }
 
+func TestFromMap(t *testing.T) {
+	for _, gen := range []dataGen{genSeq(10000, 0), genRand(10000, 1<<20), genSparse(1000), genBoundary(), genMixed()} {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			set := make(map[uint32]struct{}, len(data))
+			for _, v := range data {
+				set[v] = struct{}{}
+			}
+
+			rb := FromMap(set)
+			assert.NoError(t, rb.Validate())
+			assert.Equal(t, len(set), rb.Count())
+			bitmapsEqual(t, FromArray(data), rb)
+
+			out := make(map[uint32]struct{}, rb.Count())
+			rb.Range(func(x uint32) bool {
+				out[x] = struct{}{}
+				return true
+			})
+			assert.Equal(t, set, out)
+		})
+	}
+
+	assert.Equal(t, 0, FromMap(nil).Count())
+}
+
+func TestOf(t *testing.T) {
+	rb := Of(10, 3, 70000, 3, 10, 4294967295, 0)
+	assert.NoError(t, rb.Validate())
+	assert.Equal(t, 5, rb.Count())
+	assert.Equal(t, []uint32{0, 3, 10, 70000, 4294967295}, rb.ToArray())
+	for _, v := range []uint32{0, 3, 10, 70000, 4294967295} {
+		assert.True(t, rb.Contains(v))
+	}
+	assert.False(t, rb.Contains(4))
+
+	data, _ := genMixed()()
+	bitmapsEqual(t, FromArray(data), Of(data...))
+	assert.Equal(t, 0, Of().Count())
+}
+
+func TestFromRange(t *testing.T) {
+	for _, tc := range []struct {
+		lo, hi uint32
+		ctrs   int
+	}{
+		{5, 6, 1},
+		{5, 1000, 1},
+		{0, 65536, 1},
+		{65535, 65537, 2},
+		{100, 3<<16 + 7, 4},
+		{4294967295 - 70000, 4294967295, 2},
+		{0, 4294967295, 65536},
+	} {
+		rb := FromRange(tc.lo, tc.hi)
+		assert.NoError(t, rb.Validate())
+		assert.Equal(t, int(tc.hi-tc.lo), rb.Count())
+		assert.Equal(t, tc.ctrs, len(rb.containers))
+		assert.True(t, rb.Contains(tc.lo))
+		assert.True(t, rb.Contains(tc.hi-1))
+		assert.False(t, rb.Contains(tc.hi))
+		if tc.lo > 0 {
+			assert.False(t, rb.Contains(tc.lo-1))
+		}
+
+		expect := New()
+		expect.AddRange(tc.lo, tc.hi)
+		assert.True(t, expect.Equals(rb))
+		for _, c := range rb.containers {
+			assert.Equal(t, typeRun, c.Type)
+		}
+
+		// The containers can grow without overwriting each other
+		rb.Set(tc.hi)
+		rb.Remove(tc.lo + 1)
+		assert.NoError(t, rb.Validate())
+		assert.Equal(t, int(tc.hi-tc.lo), rb.Count())
+	}
+
+	assert.Equal(t, 0, FromRange(10, 10).Count())
+	assert.Equal(t, 0, FromRange(10, 5).Count())
+}
+
+func TestCanonicalize(t *testing.T) {
+	data := []uint32{1, 5, 10, 4294967295}
+	data = append(data, seq(1<<16, 3<<16, 3)...)              // bitmaps
+	data = append(data, seq(5<<16, 5<<16+50000, 1)...)        // single run
+	data = append(data, seq(6<<16+10, 6<<16+5000, 2)...)      // array
+	data = append(data, seq(7<<16, 7<<16+4096, 1)...)         // adjacent runs
+	data = append(data, seq(7<<16+4096, 7<<16+4096*2, 2)...)  // ...as large as a bitmap
+	data = append(data, seq(8<<16+100, 8<<16+100+1000, 1)...) // small run
+
+	builders := map[string]func() *Bitmap{
+		"set": func() *Bitmap {
+			rb := New()
+			rb.SetOptimizeInterval(0)
+			for _, v := range data {
+				rb.Set(v)
+			}
+			return rb
+		},
+		"array": func() *Bitmap {
+			return FromArray(data)
+		},
+		"optimized": func() *Bitmap {
+			rb := FromArray(data)
+			rb.Optimize()
+			return rb
+		},
+		"ranges": func() *Bitmap {
+			rb := New()
+			for _, v := range data {
+				rb.AddRangeClosed(v, v)
+			}
+			return rb
+		},
+		"bitmaps": func() *Bitmap {
+			rb := New()
+			rb.AddRange(0, 9<<16)
+			rb.AndNot(FromArray(data))
+			rb.Xor(FromArray(seq(0, 9<<16, 1)))
+			rb.Set(4294967295)
+			return rb
+		},
+	}
+
+	expect := FromArray(data)
+	expect.Canonicalize()
+	for name, build := range builders {
+		t.Run(name, func(t *testing.T) {
+			rb := build()
+			bitmapsEqual(t, expect, rb)
+
+			rb.Canonicalize()
+			assert.NoError(t, rb.Validate())
+			assert.Equal(t, expect.ToBytes(), rb.ToBytes())
+
+			rb.Canonicalize()
+			assert.Equal(t, expect.ToBytes(), rb.ToBytes())
+		})
+	}
+
+	types := make([]ctype, 0, len(expect.containers))
+	for _, c := range expect.containers {
+		types = append(types, c.Type)
+	}
+	// Runs win the tie with a bitmap on the container with key 7
+	assert.Equal(t, []ctype{typeArray, typeBitmap, typeBitmap, typeRun, typeArray, typeRun, typeRun, typeArray}, types)
+}
+
+func TestRunLimit(t *testing.T) {
+	c := &container{Type: typeRun}
+	for i := 0; i < 3000; i++ {
+		switch c.Type {
+		case typeRun:
+			assert.True(t, c.runSet(uint16(i*2)))
+		default:
+			assert.True(t, c.bmpSet(uint16(i*2)))
+		}
+
+		switch {
+		case i < runMaxSize:
+			assert.Equal(t, typeRun, c.Type)
+			assert.LessOrEqual(t, cap(c.Data), runMaxSize*2)
+		default:
+			assert.Equal(t, typeBitmap, c.Type)
+		}
+	}
+
+	assert.Equal(t, uint32(3000), c.Size)
+	assert.Equal(t, c.cardinality(), c.Size)
+
+	// Splitting runs by removal converts at the limit as well, without reallocating
+	split := &container{Type: typeRun, Size: 65536, Data: []uint16{0, 0xFFFF}}
+	for i := 0; i < 3000; i++ {
+		data := split.Data
+		switch split.Type {
+		case typeRun:
+			assert.True(t, split.runDel(uint16(i*2+1)))
+		default:
+			assert.True(t, split.bmpDel(uint16(i*2+1)))
+		}
+
+		switch {
+		case i < runMaxSize-1:
+			assert.Equal(t, typeRun, split.Type)
+			assert.LessOrEqual(t, cap(split.Data), runMaxSize*2)
+			if cap(data) >= len(data)+2 {
+				assert.Same(t, &data[0], &split.Data[0])
+			}
+		default:
+			assert.Equal(t, typeBitmap, split.Type)
+		}
+	}
+
+	assert.Equal(t, uint32(65536-3000), split.Size)
+	assert.Equal(t, split.cardinality(), split.Size)
+	for i := 0; i < 3000; i++ {
+		assert.True(t, c.contains(uint16(i*2)))
+		assert.False(t, c.contains(uint16(i*2+1)))
+	}
+}
+
+func TestArrayCompact(t *testing.T) {
+	rb := New()
+	for i := 0; i < 2000; i++ {
+		rb.Set(uint32(i * 3))
+	}
+
+	for i := 10; i < 2000; i++ {
+		rb.Remove(uint32(i * 3))
+	}
+
+	c := &rb.containers[0]
+	assert.Equal(t, typeArray, c.Type)
+	assert.Greater(t, cap(c.Data), 4*len(c.Data))
+
+	rb.Optimize()
+	assert.Equal(t, typeArray, c.Type)
+	assert.Equal(t, 10, cap(c.Data))
+	assert.Equal(t, 10, rb.Count())
+	assert.NoError(t, rb.Validate())
+
+	// Arrays that have not shrunk much keep their capacity
+	c.Data = append(make([]uint16, 0, 20), c.Data...)
+	rb.Optimize()
+	assert.Equal(t, 20, cap(c.Data))
+}
+
 func TestContainerConversions(t *testing.T) {
 	t.Run("empty_to_array", func(t *testing.T) {
 		rb := New()
@@ -359,6 +592,119 @@ func TestContainerOptimization(t *testing.T) {
 	})
 }
 
+func TestSharedOperand(t *testing.T) {
+	frozen := makeTestBitmap().Clone(nil) // all containers are shared
+	snapshot := slices.Clone(frozen.containers)
+	encoded := frozen.ToBytes()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, rb := range []*Bitmap{New(), FromArray([]uint32{1, 2, 3 << 16}), makeTestBitmap()} {
+				a, b := rb.Clone(nil), rb.Clone(nil)
+				a.Or(frozen)
+				b.Xor(frozen)
+				a.Set(131072 + 5000)
+				b.Set(131072 + 5000)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, snapshot, frozen.containers)
+	assert.Equal(t, encoded, frozen.ToBytes())
+}
+
+func TestSharedOwnership(t *testing.T) {
+	for _, op := range []struct {
+		name string
+		fn   func(rb, other *Bitmap)
+	}{
+		{"or", func(rb, other *Bitmap) { rb.Or(other) }},
+		{"xor", func(rb, other *Bitmap) { rb.Xor(other) }},
+	} {
+		t.Run(op.name, func(t *testing.T) {
+			rb, other := New(), New()
+			rb.ctrAdd(0, 0, newBmp(1, 2, 3))
+			other.ctrAdd(0, 0, newBmp(3, 4, 5))
+			other.ctrAdd(5, 1, newBmp(6, 7, 8))
+			op.fn(rb, other)
+
+			// Merged containers remain solely owned by the other bitmap
+			assert.False(t, other.containers[0].Shared)
+			assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() {
+				other.Set(100)
+				other.Remove(100)
+			}))
+
+			// Aliased containers are shared and forked on mutation
+			assert.True(t, other.containers[1].Shared)
+			other.Set(5<<16 | 100)
+			assert.False(t, other.containers[1].Shared)
+			assert.False(t, rb.Contains(5<<16|100))
+			assert.True(t, rb.Contains(5<<16|6))
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	rb := makeTestBitmap()
+	rb.AddRange(10<<16, 12<<16)
+	for i := uint32(0); i < 5000; i++ {
+		rb.Set(20<<16 | i*7)
+	}
+
+	snap := rb.Snapshot()
+	expect := snap.ToArray()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				assert.Equal(t, expect, snap.ToArray())
+				assert.Equal(t, len(expect), snap.Count())
+			}
+		}
+	}()
+
+	// Mutate every kind of container of the original heavily
+	rnd := rand.New(rand.NewSource(1))
+	other := makeTestBitmap()
+	for i := 0; i < 2000; i++ {
+		v := uint32(rnd.Intn(21 << 16))
+		switch i % 8 {
+		case 0:
+			rb.Set(v)
+		case 1:
+			rb.Remove(v)
+		case 2:
+			rb.AddRange(v, v+100)
+		case 3:
+			rb.RemoveRange(v, v+100)
+		case 4:
+			rb.Or(other)
+		case 5:
+			rb.Xor(other)
+		case 6:
+			rb.AndNot(FromArray([]uint32{v, v + 1, 20<<16 | 7}))
+		case 7:
+			rb.Optimize()
+		}
+	}
+
+	close(done)
+	wg.Wait()
+	assert.Equal(t, expect, snap.ToArray())
+}
+
 func TestClone(t *testing.T) {
 	t.Run("clone_empty", func(t *testing.T) {
 		original := New()
@@ -415,6 +761,40 @@ func TestClone(t *testing.T) {
 	})
 }
 
+func TestCloneTrimmed(t *testing.T) {
+	rb := makeTestBitmap()
+	for i := uint32(0); i < 100; i++ {
+		rb.Set(i << 16)
+	}
+	for i := uint32(10); i < 100; i++ {
+		rb.Remove(i << 16)
+	}
+	for i := uint32(0); i < 1000; i++ {
+		rb.Remove(131072 + i*2)
+	}
+
+	clone := rb.CloneTrimmed()
+	bitmapsEqual(t, rb, clone)
+	assert.NoError(t, clone.Validate())
+	assert.Equal(t, len(clone.containers), cap(clone.containers))
+	assert.Equal(t, len(clone.index), cap(clone.index))
+	for i, c := range clone.containers {
+		assert.Equal(t, len(c.Data), cap(c.Data))
+		assert.False(t, c.Shared)
+		assert.False(t, rb.containers[i].Shared)
+	}
+
+	// Neither is affected by changes to the other
+	clone.Remove(1)
+	clone.Set(65536 + 1)
+	rb.Set(2)
+	assert.True(t, rb.Contains(1))
+	assert.False(t, rb.Contains(65536+1))
+	assert.False(t, clone.Contains(2))
+
+	assert.Equal(t, 0, New().CloneTrimmed().Count())
+}
+
 func TestMinMax(t *testing.T) {
 	type testCase struct {
 		name string
@@ -495,28 +875,1126 @@ func TestMinMax(t *testing.T) {
 		}
 	})
 
-	/*t.Run("maxZero", func(t *testing.T) {
+	t.Run("maxZero", func(t *testing.T) {
 		for _, tc := range []testCase{
-			{"arr empty", newArr(), 0, true},
-			{"arr single", newArr(42), 41, true},
-			{"arr multiple", newArr(10, 20, 30), 29, true},
+			{"arr empty", newArr(), 65535, true},
+			{"arr single", newArr(42), 65535, true},
+			{"arr multiple", newArr(10, 20, 30), 65535, true},
 			{"arr boundary", newArr(0, 65535), 65534, true},
-			{"bmp empty", newBmp(), 0, true},
-			{"bmp single", newBmp(42), 41, true},
-			{"bmp multiple", newBmp(10, 20, 30), 29, true},
+			{"arr top", newArr(append(seq(0, 3, 1), seq(100, 65536, 1)...)...), 99, true},
+			{"bmp empty", newBmp(), 65535, true},
+			{"bmp single", newBmp(42), 65535, true},
+			{"bmp multiple", newBmp(10, 20, 30), 65535, true},
 			{"bmp boundary", newBmp(0, 65535), 65534, true},
-			{"run empty", newRun(), 0, true},
-			{"run single", newRun(42), 41, true},
-			{"run multiple", newRun(10, 11, 12, 20, 21, 22), 13, true},
+			{"bmp top", newBmp(seq(5, 65536, 1)...), 4, true},
+			{"bmp full", newBmp(seq(0, 65536, 1)...), 0, false},
+			{"run empty", newRun(), 65535, true},
+			{"run single", newRun(42), 65535, true},
+			{"run multiple", newRun(10, 11, 12, 20, 21, 22), 65535, true},
 			{"run boundary", newRun(0, 1, 65535), 65534, true},
+			{"run top", newRun(append(seq(10, 13, 1), seq(20, 65536, 1)...)...), 19, true},
+			{"run full", newRun(seq(0, 65536, 1)...), 0, false},
 		} {
 			t.Run(tc.name, func(t *testing.T) {
-				rb, _ := bitmapWith(tc.cnr)
-				maxZero, maxZeroOk := rb.MaxZero()
+				maxZero, maxZeroOk := tc.cnr.maxZero()
 				assert.Equal(t, tc.has, maxZeroOk, "maxZero() ok result")
-				assert.Equal(t, tc.val, maxZero, "maxZero() value")
+				assert.Equal(t, tc.val, uint32(maxZero), "maxZero() value")
+
+				// Values above the container are unset, unless it is the last one
+				rb, _ := bitmapWith(tc.cnr)
+				maxZero32, ok := rb.MaxZero()
+				assert.True(t, ok)
+				assert.Equal(t, uint32(4294967295), maxZero32)
+			})
+		}
+	})
+
+	t.Run("maxZero containers", func(t *testing.T) {
+		const top = uint32(4294967295)
+		for _, tc := range []struct {
+			name  string
+			build func(rb *Bitmap)
+			val   uint32
+			has   bool
+		}{
+			{"empty", func(rb *Bitmap) {}, top, true},
+			{"max set", func(rb *Bitmap) { rb.Set(top) }, top - 1, true},
+			{"last full", func(rb *Bitmap) { rb.AddRangeClosed(65535<<16, top) }, 65535<<16 - 1, true},
+			{"hole below", func(rb *Bitmap) {
+				rb.AddRangeClosed(65534<<16, top)
+				rb.Remove(65534<<16 | 7)
+			}, 65534<<16 | 7, true},
+			{"gap below", func(rb *Bitmap) {
+				rb.AddRangeClosed(65533<<16, top)
+				rb.AddRange(0, 65536)
+			}, 65533<<16 - 1, true},
+			{"only zero", func(rb *Bitmap) { rb.AddRangeClosed(1, top) }, 0, true},
+			{"full", func(rb *Bitmap) { rb.AddRangeClosed(0, top) }, 0, false},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				rb := New()
+				tc.build(rb)
+				maxZero, ok := rb.MaxZero()
+				assert.Equal(t, tc.has, ok)
+				assert.Equal(t, tc.val, maxZero)
+				if ok {
+					assert.False(t, rb.Contains(maxZero))
+					assert.Equal(t, uint64(0), rb.AbsentCountInRange64(uint64(maxZero)+1, 1<<32))
+				}
+			})
+		}
+	})
+}
+
+func TestNextAbsentValue(t *testing.T) {
+	const top = uint32(4294967295)
+	for _, tc := range []struct {
+		name   string
+		build  func(rb *Bitmap)
+		x, val uint32
+	}{
+		{"empty", func(rb *Bitmap) {}, 42, 42},
+		{"absent", func(rb *Bitmap) { rb.Set(1) }, 2, 2},
+		{"no container", func(rb *Bitmap) { rb.Set(1) }, 3 << 16, 3 << 16},
+		{"within", func(rb *Bitmap) { rb.AddRange(10, 20) }, 12, 20},
+		{"full container", func(rb *Bitmap) {
+			rb.AddRange(0, 65536)
+			rb.AddRange(65536, 65536+5)
+		}, 7, 65536 + 5},
+		{"gap after full", func(rb *Bitmap) {
+			rb.AddRange(0, 65536)
+			rb.Set(2<<16 | 3)
+		}, 100, 65536},
+		{"full containers", func(rb *Bitmap) {
+			rb.AddRange(0, 3<<16)
+			rb.Set(3<<16 | 1)
+		}, 5, 3 << 16},
+		{"hole after full", func(rb *Bitmap) {
+			rb.AddRange(0, 4<<16)
+			rb.Remove(3<<16 | 9)
+		}, 1, 3<<16 | 9},
+		{"top absent", func(rb *Bitmap) { rb.AddRange(65535<<16, top) }, 65535 << 16, top},
+		{"top present", func(rb *Bitmap) { rb.Set(top) }, top, top},
+		{"full", func(rb *Bitmap) { rb.AddRangeClosed(0, top) }, 123, top},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rb := New()
+			tc.build(rb)
+			assert.Equal(t, tc.val, rb.NextAbsentValue(tc.x))
+		})
+	}
+
+	t.Run("representations", func(t *testing.T) {
+		var values []uint32
+		for i := 0; i < 4; i++ {
+			values = append(values, seq(i<<16, i<<16+300, 1)...)
+			values = append(values, seq(i<<16+1000, i<<16+1100, 3)...)
+			values = append(values, seq(i<<16+65000, i<<16+65536, 1)...)
+		}
+
+		for _, typ := range []ContainerType{ContainerArray, ContainerBitmap, ContainerRun} {
+			rb := NewForTest(typ, values...)
+			for x := uint32(0); x < 5<<16; x += 7 {
+				expect := x
+				for rb.Contains(expect) {
+					expect++
+				}
+
+				assert.Equal(t, expect, rb.NextAbsentValue(x), "%s at %d", typ, x)
+			}
+		}
+	})
+
+	t.Run("adjacent runs", func(t *testing.T) {
+		rb := bitmapOf(&container{Type: typeRun, Size: 65535, Data: []uint16{0, 9, 10, 19, 21, 65535}})
+		assert.Equal(t, uint32(20), rb.NextAbsentValue(0))
+		assert.Equal(t, uint32(65536), rb.NextAbsentValue(21))
+	})
+}
+
+// TestMinMaxMutations interleaves every kind of mutation with Min and Max, comparing them
+// against values recomputed from scratch so a stale first/last value cannot go unnoticed.
+func TestMinMaxMutations(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	value := func() uint32 { return uint32(rnd.Intn(4 << 16)) }
+	other := func() *Bitmap {
+		out := New()
+		lo := value()
+		out.AddRange(lo, lo+uint32(rnd.Intn(70000)))
+		for i := 0; i < 100; i++ {
+			out.Set(value())
+		}
+		return out
+	}
+
+	mutations := []func(rb *Bitmap){
+		func(rb *Bitmap) { rb.Set(value()) },
+		func(rb *Bitmap) { rb.Remove(value()) },
+		func(rb *Bitmap) {
+			if v, ok := rb.Min(); ok {
+				rb.Remove(v)
+			}
+		},
+		func(rb *Bitmap) {
+			if v, ok := rb.Max(); ok {
+				rb.Remove(v)
+			}
+		},
+		func(rb *Bitmap) { lo := value(); rb.AddRange(lo, lo+uint32(rnd.Intn(70000))) },
+		func(rb *Bitmap) { lo := value(); rb.RemoveRange(lo, lo+uint32(rnd.Intn(70000))) },
+		func(rb *Bitmap) { rb.And(other()) },
+		func(rb *Bitmap) { rb.AndNot(other()) },
+		func(rb *Bitmap) { rb.Or(other()) },
+		func(rb *Bitmap) { rb.Xor(other()) },
+		func(rb *Bitmap) { rb.Optimize() },
+		func(rb *Bitmap) { *rb = *FromBytes(other().ToBytes()) },
+		func(rb *Bitmap) {
+			_, err := rb.ReadFrom(bytes.NewReader(other().ToBytes()))
+			assert.NoError(t, err)
+		},
+	}
+
+	rb := New()
+	for i := 0; i < 2000; i++ {
+		mutations[rnd.Intn(len(mutations))](rb)
+
+		values := rb.ToArray()
+		minValue, minOk := rb.Min()
+		maxValue, maxOk := rb.Max()
+		assert.Equal(t, len(values) > 0, minOk)
+		assert.Equal(t, len(values) > 0, maxOk)
+		if len(values) > 0 {
+			assert.Equal(t, values[0], minValue)
+			assert.Equal(t, values[len(values)-1], maxValue)
+		}
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	assert.True(t, New().IsEmpty())
+	assert.True(t, FromArray(nil).IsEmpty())
+
+	// Emptied by removing every value
+	rb := makeTestBitmap()
+	assert.False(t, rb.IsEmpty())
+	for _, v := range rb.ToArray() {
+		rb.Remove(v)
+	}
+	assert.True(t, rb.IsEmpty())
+
+	// Emptied by a disjoint intersection
+	rb = makeTestBitmap()
+	rb.And(FromArray([]uint32{2, 3 << 16}))
+	assert.True(t, rb.IsEmpty())
+
+	// Stray empty containers are ignored
+	rb = New()
+	rb.ctrAdd(3, 0, &container{Type: typeArray, Data: []uint16{}})
+	assert.True(t, rb.IsEmpty())
+	rb.Set(5 << 16)
+	assert.False(t, rb.IsEmpty())
+}
+
+func TestNilBitmap(t *testing.T) {
+	var rb *Bitmap
+	assert.False(t, rb.Contains(0))
+	assert.False(t, rb.Contains(4294967295))
+	assert.Equal(t, 0, rb.Count())
+	assert.True(t, rb.IsEmpty())
+
+	rb.Range(func(x uint32) bool {
+		assert.Fail(t, "unexpected value", x)
+		return true
+	})
+
+	min, ok := rb.Min()
+	assert.False(t, ok)
+	assert.Equal(t, uint32(0), min)
+	max, ok := rb.Max()
+	assert.False(t, ok)
+	assert.Equal(t, uint32(0), max)
+	_, _, ok = rb.Bounds()
+	assert.False(t, ok)
+
+	// Mutations cannot grow a nil bitmap
+	assert.Panics(t, func() { rb.Set(1) })
+	assert.Panics(t, func() { rb.Remove(1) })
+}
+
+func TestBounds(t *testing.T) {
+	_, _, ok := New().Bounds()
+	assert.False(t, ok)
+
+	single := New()
+	single.Set(70000)
+	lo, hi, ok := single.Bounds()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(70000), lo)
+	assert.Equal(t, uint32(70000), hi)
+
+	rb := makeTestBitmap()
+	lo, hi, ok = rb.Bounds()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), lo)
+	assert.Equal(t, uint32(4294967295), hi)
+
+	rb.Remove(4294967295)
+	rb.Remove(1)
+	lo, hi, ok = rb.Bounds()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5), lo)
+	assert.Equal(t, uint32(131072+999), hi)
+}
+
+func TestSelect(t *testing.T) {
+	ctors := map[string]func(...uint32) *container{"arr": newArr, "bmp": newBmp, "run": newRun}
+	for name, ctor := range ctors {
+		t.Run(name, func(t *testing.T) {
+			values := append(append(seq(0, 100, 1), seq(200, 5000, 3)...), seq(65000, 65536, 1)...)
+			rb := bitmapOf(ctor(values...))
+			for i, v := range values {
+				x, ok := rb.Select(uint32(i))
+				assert.True(t, ok)
+				assert.Equal(t, v, x)
+				assert.Equal(t, i+1, rb.Rank(v))
+			}
+
+			_, ok := rb.Select(uint32(len(values)))
+			assert.False(t, ok)
+		})
+	}
+
+	for _, gen := range []dataGen{genSeq(100000, 0), genRand(10000, 1<<24), genSparse(1000), genDense(10000), genBoundary(), genMixed()} {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			rb, _ := testPair(data)
+			values := rb.ToArray()
+			for _, x := range values {
+				v, ok := rb.Select(uint32(rb.Rank(x) - 1))
+				assert.True(t, ok)
+				assert.Equal(t, x, v)
+			}
+
+			_, ok := rb.Select(uint32(rb.Count()))
+			assert.False(t, ok)
+		})
+	}
+
+	// Absent values rank after the values below them
+	rb := makeTestBitmap()
+	assert.Equal(t, 0, rb.Rank(0))
+	assert.Equal(t, 2, rb.Rank(7))
+	assert.Equal(t, rb.Count(), rb.Rank(4294967295))
+	assert.Equal(t, rb.Count()-1, rb.Rank(4294967294))
+
+	_, ok := New().Select(0)
+	assert.False(t, ok)
+	assert.Equal(t, 0, New().Rank(4294967295))
+}
+
+func TestFullContainer(t *testing.T) {
+	full := func() *Bitmap {
+		rb := New()
+		rb.AddRange(65536, 131072)
+		return rb
+	}
+
+	t.Run("add_range", func(t *testing.T) {
+		rb := full()
+		assert.Equal(t, 65536, rb.Count())
+		assert.Equal(t, 1, len(rb.containers))
+		assert.Equal(t, typeRun, rb.containers[0].Type)
+		assert.Equal(t, []uint16{0, 0xFFFF}, rb.containers[0].Data)
+		assert.False(t, rb.Contains(65535))
+		assert.True(t, rb.Contains(65536))
+		assert.True(t, rb.Contains(131071))
+		assert.False(t, rb.Contains(131072))
+	})
+
+	t.Run("add_range_over_existing", func(t *testing.T) {
+		for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+			rb, _ := changeType(typ)
+			rb.AddRange(0, 65536)
+			assert.Equal(t, 65536, rb.Count())
+			assert.Equal(t, typeRun, rb.containers[0].Type)
+			assert.Equal(t, []uint16{0, 0xFFFF}, rb.containers[0].Data)
+		}
+	})
+
+	t.Run("min_zero", func(t *testing.T) {
+		rb := full()
+		_, ok := rb.containers[0].minZero()
+		assert.False(t, ok)
+
+		min, ok := rb.Min()
+		assert.True(t, ok)
+		assert.Equal(t, uint32(65536), min)
+
+		max, ok := rb.Max()
+		assert.True(t, ok)
+		assert.Equal(t, uint32(131071), max)
+
+		rb.AddRange(0, 65536)
+		zero, ok := rb.MinZero()
+		assert.True(t, ok)
+		assert.Equal(t, uint32(131072), zero)
+	})
+
+	t.Run("codec", func(t *testing.T) {
+		rb := full()
+		out := FromBytes(rb.ToBytes())
+		assert.Equal(t, 65536, out.Count())
+		assert.Equal(t, uint32(65536), out.containers[0].Size)
+		assert.Equal(t, typeRun, out.containers[0].Type)
+		bitmapsEqual(t, rb, out)
+	})
+
+	t.Run("math", func(t *testing.T) {
+		for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+			other, values := changeType(typ)
+			for i := range values {
+				values[i] += 65536
+			}
+
+			other.Clear()
+			for _, v := range values {
+				other.Set(v)
+			}
+			other.Optimize()
+
+			and := full()
+			and.And(other)
+			assert.Equal(t, len(values), and.Count())
+
+			or := full()
+			or.Or(other)
+			assert.Equal(t, 65536, or.Count())
+
+			xor := full()
+			xor.Xor(other)
+			assert.Equal(t, 65536-len(values), xor.Count())
+
+			andNot := full()
+			andNot.AndNot(other)
+			assert.Equal(t, 65536-len(values), andNot.Count())
+			for _, v := range values {
+				assert.True(t, and.Contains(v))
+				assert.True(t, or.Contains(v))
+				assert.False(t, xor.Contains(v))
+				assert.False(t, andNot.Contains(v))
+			}
+		}
+	})
+}
+
+func TestAddRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		lo, hi uint32
+	}{
+		{"empty", 10, 10},
+		{"inverted", 20, 10},
+		{"single", 7, 8},
+		{"within container", 3, 700},
+		{"within bitmap", 65536 + 100, 65536 + 20000},
+		{"across containers", 5, 131072 + 500},
+		{"entire container", 65536, 131072},
+		{"new containers", 1000000, 2000000},
+		{"last container", 4294967295 - 70000, 4294967295},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb, expect := makeTestBitmap(), makeTestBitmap()
+			for v := uint64(tt.lo); v < uint64(tt.hi); v++ {
+				expect.Set(uint32(v))
+			}
+
+			rb.AddRange(tt.lo, tt.hi)
+			assert.NoError(t, rb.Validate())
+			bitmapsEqual(t, expect, rb)
+			for _, v := range []uint32{tt.lo - 1, tt.lo, tt.hi - 1, tt.hi} {
+				assert.Equal(t, expect.Contains(v), rb.Contains(v))
+			}
+		})
+	}
+
+	// Containers spanned entirely are a single run
+	rb := New()
+	rb.AddRange(1000000, 2000000)
+	assert.Equal(t, 1000000, rb.Count())
+	for i, c := range rb.containers[1 : len(rb.containers)-1] {
+		assert.Equal(t, typeRun, c.Type, "container %d", i+1)
+		assert.Equal(t, []uint16{0, 0xFFFF}, c.Data)
+	}
+}
+
+func TestRemoveRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		lo, hi uint32
+	}{
+		{"empty", 10, 10},
+		{"inverted", 20, 10},
+		{"within container", 3, 700},
+		{"across containers", 5, 131072 + 500},
+		{"entire container", 65536, 131072},
+		{"everything", 0, 4294967295},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb, expect := makeTestBitmap(), makeTestBitmap()
+			expect.Filter(func(x uint32) bool {
+				return x < tt.lo || x >= tt.hi
 			})
+
+			rb.RemoveRange(tt.lo, tt.hi)
+			assert.NoError(t, rb.Validate())
+			bitmapsEqual(t, expect, rb)
+		})
+	}
+}
+
+func TestFlip(t *testing.T) {
+	values := []uint32{0, 1, 5, 65535, 65536, 131072 + 999, 1 << 30, 4294967295}
+	for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+		for n := 1; n <= 4; n++ {
+			rb, _ := changeType(typ)
+			expect, _ := changeType(typ)
+			for _, v := range values {
+				for i := 0; i < n; i++ {
+					rb.Flip(v)
+				}
+
+				// Odd flips toggle the membership, even flips leave it unchanged
+				if n%2 == 1 {
+					if expect.Contains(v) {
+						expect.Remove(v)
+					} else {
+						expect.Set(v)
+					}
+				}
+			}
+
+			assert.NoError(t, rb.Validate())
+			bitmapsEqual(t, expect, rb)
+		}
+	}
+
+	t.Run("remove container", func(t *testing.T) {
+		rb := FromArray([]uint32{7, 65536 + 7})
+		rb.Flip(65536 + 7)
+		assert.Equal(t, []uint16{0}, rb.index)
+		rb.Flip(7)
+		assert.Empty(t, rb.containers)
+		assert.True(t, rb.IsEmpty())
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		rb := makeTestBitmap()
+		clone := rb.Clone(nil)
+		clone.Flip(5)
+		clone.Flip(6)
+		assert.True(t, rb.Contains(5))
+		assert.False(t, rb.Contains(6))
+		assert.False(t, clone.Contains(5))
+		assert.True(t, clone.Contains(6))
+	})
+}
+
+func TestFlipRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		lo, hi uint32
+	}{
+		{"empty", 10, 10},
+		{"inverted", 20, 10},
+		{"single", 5, 6},
+		{"within container", 3, 700},
+		{"within bitmap", 65536 + 100, 65536 + 20000},
+		{"container boundary", 65535, 65537},
+		{"across containers", 5, 131072 + 500},
+		{"entire container", 65536, 131072},
+		{"new containers", 1000000, 1300000},
+		{"last container", 4294967295 - 70000, 4294967295},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb, expect := makeTestBitmap(), makeTestBitmap()
+			for v := uint64(tt.lo); v < uint64(tt.hi); v++ {
+				if !expect.Contains(uint32(v)) {
+					expect.Set(uint32(v))
+				} else {
+					expect.Remove(uint32(v))
+				}
+			}
+
+			rb.FlipRange(tt.lo, tt.hi)
+			assert.NoError(t, rb.Validate())
+			bitmapsEqual(t, expect, rb)
+
+			// Flipping again restores the original values
+			rb.FlipRange(tt.lo, tt.hi)
+			assert.NoError(t, rb.Validate())
+			bitmapsEqual(t, makeTestBitmap(), rb)
+		})
+	}
+
+	// Every kind of container is complemented within its key
+	for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+		rb, values := changeType(typ)
+		rb.FlipRange(0, 65536)
+		assert.NoError(t, rb.Validate())
+		assert.Equal(t, 65536-len(values), rb.Count())
+		for _, v := range values {
+			assert.False(t, rb.Contains(v))
+		}
+
+		rb.FlipRange(0, 65536)
+		assert.Equal(t, values, rb.ToArray())
+	}
+
+	t.Run("remove container", func(t *testing.T) {
+		rb := New()
+		rb.AddRange(65530, 65536+10)
+		rb.FlipRange(65536, 65536+10)
+		assert.Equal(t, []uint16{0}, rb.index)
+		assert.Equal(t, seq(65530, 65536, 1), rb.ToArray())
+
+		rb.FlipRange(65530, 65536)
+		assert.Empty(t, rb.containers)
+		assert.True(t, rb.IsEmpty())
+	})
+}
+
+func TestSetAllExcept(t *testing.T) {
+	var absent bitmap.Bitmap
+	blocked := []uint32{0, 1, 63, 64, 1000, 40000, 65535}
+	for _, v := range blocked {
+		absent.Set(v)
+	}
+
+	for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+		rb, _ := changeType(typ)
+		rb.Set(1 << 20)
+		rb.SetAllExcept(0, absent)
+		assert.NoError(t, rb.Validate())
+		assert.Equal(t, 65536-len(blocked)+1, rb.Count())
+		assert.True(t, rb.Contains(2))
+		assert.True(t, rb.Contains(65534))
+		assert.True(t, rb.Contains(1<<20))
+		for _, v := range blocked {
+			assert.False(t, rb.Contains(v))
+		}
+	}
+
+	// A few holes are best stored as runs, and the container is created if missing
+	rb := New()
+	rb.SetAllExcept(7, absent)
+	assert.Equal(t, []uint16{7}, rb.index)
+	assert.Equal(t, typeRun, rb.containers[0].Type)
+	assert.Equal(t, 65536-len(blocked), rb.Count())
+	assert.Equal(t, 0, rb.CountRange(7<<16, 7<<16+2))
+
+	// Short buckets leave the remaining values set, long ones are cut to the container
+	rb.SetAllExcept(7, bitmap.Bitmap{0xFF})
+	assert.Equal(t, 65536-8, rb.Count())
+	rb.SetAllExcept(7, nil)
+	assert.Equal(t, 65536, rb.Count())
+	rb.SetAllExcept(7, make(bitmap.Bitmap, 2048))
+	assert.Equal(t, 65536, rb.Count())
+
+	// Everything absent leaves nothing behind
+	all := make(bitmap.Bitmap, 1024)
+	for i := range all {
+		all[i] = ^uint64(0)
+	}
+	rb.SetAllExcept(7, all)
+	rb.SetAllExcept(8, all)
+	assert.Empty(t, rb.containers)
+}
+
+func TestRangeAliases(t *testing.T) {
+	const maxValue = uint32(4294967295)
+	for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+		t.Run("clear", func(t *testing.T) {
+			a, _ := changeType(typ)
+			b, _ := changeType(typ)
+			a.RemoveRange(3, 1500)
+			b.ClearRange(3, 1500)
+			bitmapsEqual(t, a, b)
+		})
+
+		t.Run("set", func(t *testing.T) {
+			a, _ := changeType(typ)
+			b, _ := changeType(typ)
+			a.AddRange(3, 70000)
+			b.SetRange(3, 70000)
+			bitmapsEqual(t, a, b)
+		})
+	}
+
+	t.Run("closed", func(t *testing.T) {
+		a, b := New(), New()
+		a.AddRangeClosed(maxValue-10, maxValue)
+		b.SetRange(maxValue-10, maxValue)
+		assert.Equal(t, 11, a.Count())
+		assert.Equal(t, 10, b.Count())
+		assert.True(t, a.Contains(maxValue))
+		assert.False(t, b.Contains(maxValue))
+
+		a.RemoveRangeClosed(maxValue-5, maxValue)
+		b.ClearRange(maxValue-5, maxValue)
+		assert.Equal(t, 5, a.Count())
+		assert.Equal(t, 5, b.Count())
+		bitmapsEqual(t, a, b)
+
+		a.RemoveRangeClosed(0, maxValue)
+		assert.Equal(t, 0, a.Count())
+		a.AddRangeClosed(maxValue, maxValue)
+		a.AddRangeClosed(2, 1)
+		assert.Equal(t, []uint32{maxValue}, a.ToArray())
+	})
+}
+
+func TestUniqueKeys(t *testing.T) {
+	assertUnique := func(t *testing.T, rb *Bitmap) {
+		t.Helper()
+		for i := 1; i < len(rb.index); i++ {
+			assert.Less(t, rb.index[i-1], rb.index[i], "key %d at %d", rb.index[i], i)
+		}
+	}
+
+	// Keys are inserted in every order, and set again once they exist
+	rb := New()
+	for _, v := range rand.Perm(200) {
+		rb.Set(uint32(v) << 16)
+		rb.Set(uint32(v)<<16 | 1)
+	}
+	for v := 199; v >= 0; v-- {
+		rb.Set(uint32(v)<<16 | 2)
+	}
+
+	assert.Equal(t, 200, len(rb.index))
+	assertUnique(t, rb)
+
+	// Decoding preserves the keys
+	out, err := FromBytesSafe(rb.ToBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, rb.index, out.index)
+	assertUnique(t, out)
+
+	// Decoding into a non-empty bitmap replaces its keys rather than adding to them
+	dst := makeTestBitmap()
+	_, err = dst.ReadFrom(bytes.NewReader(rb.ToBytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, rb.index, dst.index)
+}
+
+func TestSetMany(t *testing.T) {
+	for _, gen := range []dataGen{
+		genSeq(100000, 0),
+		genRand(10000, 1000000),
+		genSparse(1000),
+		genDense(10000),
+		genMixed(),
+	} {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			expect := makeTestBitmap()
+			for _, v := range data {
+				expect.Set(v)
+			}
+
+			// The input is left in its original order
+			input := slices.Clone(data)
+			rb := makeTestBitmap()
+			rb.SetMany(input)
+			assert.Equal(t, data, input)
+			assert.NoError(t, rb.Validate())
+			bitmapsEqual(t, expect, rb)
+
+			slices.Sort(input)
+			sorted := makeTestBitmap()
+			sorted.SetManySorted(input)
+			assert.NoError(t, sorted.Validate())
+			bitmapsEqual(t, expect, sorted)
+		})
+	}
+
+	// Groups densely filling a new container are built as runs or bitmaps directly
+	rb := New()
+	rb.SetMany(append(seq(0, 1000, 1), seq(65536, 65536+30000, 2)...))
+	assert.Equal(t, typeRun, rb.containers[0].Type)
+	assert.Equal(t, typeBitmap, rb.containers[1].Type)
+
+	rb.SetMany(nil)
+	assert.Equal(t, 16000, rb.Count())
+}
+
+func TestRemoveMany(t *testing.T) {
+	for _, gen := range []dataGen{
+		genSeq(100000, 0),
+		genRand(10000, 1000000),
+		genSparse(1000),
+		genDense(10000),
+		genMixed(),
+	} {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			base, _ := genRand(50000, 1000000)()
+			base = append(base, data[:len(data)/2]...)
+
+			// Values are repeated, some are absent and the input is left in its order
+			input := append(slices.Clone(data), data[:len(data)/3]...)
+			rand.Shuffle(len(input), func(i, j int) { input[i], input[j] = input[j], input[i] })
+			original := slices.Clone(input)
+
+			expect := FromArray(base)
+			for _, v := range input {
+				expect.Remove(v)
+			}
+
+			rb := FromArray(base)
+			rb.RemoveMany(input)
+			assert.Equal(t, original, input)
+			assert.NoError(t, rb.Validate())
+			bitmapsEqual(t, expect, rb)
+		})
+	}
+
+	// Containers which became empty are deleted
+	rb := FromArray([]uint32{1, 2, 65536, 65537, 1 << 20})
+	rb.RemoveMany([]uint32{65537, 2, 65536, 65536, 7 << 16})
+	assert.Equal(t, []uint16{0, 16}, rb.index)
+	assert.Equal(t, []uint32{1, 1 << 20}, rb.ToArray())
+
+	rb.RemoveMany(nil)
+	rb.RemoveMany([]uint32{1 << 20, 1})
+	assert.Empty(t, rb.containers)
+}
+
+func TestAppendSorted(t *testing.T) {
+	data, _ := genRand(100000, 1<<22)()
+	slices.Sort(data)
+
+	rb := New()
+	for _, v := range data {
+		assert.NoError(t, rb.AppendSorted(v))
+	}
+
+	assert.NoError(t, rb.Validate())
+	bitmapsEqual(t, FromArray(data), rb)
+
+	// Appending onto a shared container leaves the clone untouched
+	last := data[len(data)-1]
+	clone := rb.Clone(nil)
+	assert.NoError(t, rb.AppendSorted(last+1))
+	assert.True(t, rb.Contains(last+1))
+	assert.False(t, clone.Contains(last+1))
+
+	// Equal values are accepted, smaller ones are not
+	assert.NoError(t, rb.AppendSorted(last+1))
+	assert.ErrorIs(t, rb.AppendSorted(last), ErrUnsorted)
+	assert.ErrorIs(t, rb.AppendSorted(0), ErrUnsorted)
+	assert.NoError(t, rb.AppendSorted(4294967295))
+	assert.ErrorIs(t, rb.AppendSorted(last+2), ErrUnsorted)
+	assert.Equal(t, FromArray(data).Count()+2, rb.Count())
+}
+
+func TestContainsSingle(t *testing.T) {
+	for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+		rb, values := changeType(typ)
+		assert.Equal(t, 1, len(rb.containers))
+		for _, v := range values {
+			assert.True(t, rb.Contains(v))
+			assert.False(t, rb.Contains(v|1<<16))
+		}
+		assert.False(t, rb.Contains(4294967295))
+	}
+}
+
+func TestProbe(t *testing.T) {
+	for typ, expect := range map[ctype]ContainerType{
+		typeArray:  ContainerArray,
+		typeBitmap: ContainerBitmap,
+		typeRun:    ContainerRun,
+	} {
+		t.Run(expect.String(), func(t *testing.T) {
+			rb, values := changeType(typ)
+			for _, v := range values {
+				found, kind := rb.Probe(v)
+				assert.True(t, found)
+				assert.Equal(t, expect, kind)
+			}
+
+			// Absent from an existing container, the type is still reported
+			found, kind := rb.Probe(65535)
+			assert.False(t, found)
+			assert.Equal(t, expect, kind)
+
+			// Absent container
+			found, kind = rb.Probe(1 << 16)
+			assert.False(t, found)
+			assert.Equal(t, ContainerNone, kind)
+		})
+	}
+
+	found, kind := New().Probe(0)
+	assert.False(t, found)
+	assert.Equal(t, ContainerNone, kind)
+	assert.Equal(t, "none", kind.String())
+}
+
+func BenchmarkContains(b *testing.B) {
+	for name, typ := range map[string]ctype{"arr": typeArray, "bmp": typeBitmap, "run": typeRun} {
+		rb, values := changeType(typ)
+		b.Run("single-"+name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rb.Contains(values[i%len(values)])
+			}
+		})
+	}
+}
+
+func BenchmarkAppendSorted(b *testing.B) {
+	data, _ := genRand(1e6, 1<<24)()
+	slices.Sort(data)
+
+	b.Run("set", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rb := New()
+			for _, v := range data {
+				rb.Set(v)
+			}
+		}
+	})
+
+	b.Run("append-sorted", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rb := New()
+			for _, v := range data {
+				rb.AppendSorted(v)
+			}
+		}
+	})
+}
+
+func TestPinType(t *testing.T) {
+	rb := New()
+	rb.Set(1)
+	rb.Set(1 << 16)
+	rb.PinType(0, ContainerArray)
+	rb.PinType(7, ContainerBitmap) // No container to pin
+
+	// The pinned array does not convert to a bitmap even past arrMinSize
+	for v := uint32(0); v < 4*arrMinSize*4; v += 4 {
+		rb.Set(v)
+		rb.Set(1<<16 | v)
+	}
+
+	rb.Optimize()
+	assert.NoError(t, rb.Validate())
+	_, typ := rb.Probe(0)
+	assert.Equal(t, ContainerArray, typ)
+	_, typ = rb.Probe(1 << 16)
+	assert.Equal(t, ContainerBitmap, typ)
+
+	// Operations keep the pinned array valid
+	other := FromArray(seq(0, 4*arrMinSize*4, 3))
+	for _, op := range []func(*Bitmap){
+		func(rb *Bitmap) { rb.Or(other) },
+		func(rb *Bitmap) { rb.And(other) },
+		func(rb *Bitmap) { rb.Xor(other) },
+		func(rb *Bitmap) { rb.AndNot(other) },
+	} {
+		pinned, expect := rb.Clone(nil), rb.Clone(nil)
+		expect.PinType(0, ContainerNone)
+		op(pinned)
+		op(expect)
+		assert.NoError(t, pinned.Validate())
+		assert.Equal(t, expect.ToArray(), pinned.ToArray())
+	}
+
+	// A sparse bucket can be kept as a bitmap until unpinned
+	rb = FromArray([]uint32{1, 2, 100})
+	rb.PinType(0, ContainerBitmap)
+	rb.Optimize()
+	_, typ = rb.Probe(1)
+	assert.Equal(t, ContainerBitmap, typ)
+
+	rb.PinType(0, ContainerNone)
+	rb.Optimize()
+	_, typ = rb.Probe(1)
+	assert.Equal(t, ContainerArray, typ)
+	assert.Equal(t, []uint32{1, 2, 100}, rb.ToArray())
+}
+
+func TestPinTypeKernels(t *testing.T) {
+	values := seq(0, 65536, 3)
+	operands := map[string]*Bitmap{
+		"arr": NewForTest(ContainerArray, 1, 2, 3, 300),
+		"bmp": NewForTest(ContainerBitmap, seq(0, 65536, 2)...),
+		"run": NewForTest(ContainerRun, seq(100, 60000, 1)...),
+	}
+
+	ops := map[string]func(rb, other *Bitmap){
+		"and":    func(rb, other *Bitmap) { rb.And(other) },
+		"andnot": func(rb, other *Bitmap) { rb.AndNot(other) },
+		"or":     func(rb, other *Bitmap) { rb.Or(other) },
+		"xor":    func(rb, other *Bitmap) { rb.Xor(other) },
+	}
+
+	// Kernels such as bitmap ∧ array or array ∨ bitmap change the type of the result
+	for _, typ := range []ContainerType{ContainerArray, ContainerBitmap, ContainerRun} {
+		for operand, other := range operands {
+			for op, fn := range ops {
+				t.Run(typ.String()+" "+op+" "+operand, func(t *testing.T) {
+					pinned, expect := NewForTest(typ, values...), FromArray(values)
+					pinned.PinType(0, typ)
+					fn(pinned, other)
+					fn(expect, other)
+
+					assert.NoError(t, pinned.Validate())
+					assert.Equal(t, expect.ToArray(), pinned.ToArray())
+					if v, ok := pinned.Min(); ok {
+						_, actual := pinned.Probe(v)
+						assert.Equal(t, typ, actual)
+					}
+				})
+			}
+		}
+	}
+
+	// Growing or shrinking a pinned container one value at a time
+	arr := FromArray([]uint32{1})
+	arr.PinType(0, ContainerArray)
+	for v := uint32(0); v < 2*arrMinSize; v++ {
+		arr.Set(v * 2)
+	}
+
+	run := FromRange(0, 1<<16)
+	run.PinType(0, ContainerRun)
+	for v := uint32(0); v < 2*runMaxSize; v++ {
+		run.Remove(v * 2)
+	}
+
+	bmp := NewForTest(ContainerBitmap, seq(0, 4000, 1)...)
+	bmp.PinType(0, ContainerBitmap)
+	for v := uint32(0); v < 3990; v++ {
+		bmp.Remove(v)
+	}
+
+	// Filling a pinned container entirely keeps its type and its pin
+	full := NewForTest(ContainerBitmap, 1, 2, 3)
+	full.PinType(0, ContainerBitmap)
+	full.AddRange(0, 1<<16)
+	assert.Equal(t, 65536, full.Count())
+	assert.True(t, full.containers[0].Pinned)
+	assert.Equal(t, typeBitmap, full.containers[0].Type)
+	assert.NoError(t, full.Validate())
+
+	for typ, rb := range map[ContainerType]*Bitmap{ContainerArray: arr, ContainerRun: run, ContainerBitmap: bmp} {
+		v, _ := rb.Min()
+		_, actual := rb.Probe(v)
+		assert.Equal(t, typ, actual)
+		assert.NoError(t, rb.Validate())
+	}
+}
+
+func TestNewForTest(t *testing.T) {
+	values := append(append(seq(0, 1000, 1), seq(70000, 80000, 7)...), 4294967295)
+	for _, typ := range []ContainerType{ContainerArray, ContainerBitmap, ContainerRun} {
+		rb := NewForTest(typ, values...)
+		assert.NoError(t, rb.Validate())
+		assert.Equal(t, values, rb.ToArray())
+		for _, v := range []uint32{0, 70000, 4294967295} {
+			found, actual := rb.Probe(v)
+			assert.True(t, found)
+			assert.Equal(t, typ, actual)
+		}
+
+		// Mutations keep the representation until it is optimized explicitly
+		for v := uint32(2000); v < 6000; v++ {
+			rb.Set(v)
 		}
-	})*/
+		_, actual := rb.Probe(0)
+		assert.Equal(t, typ, actual)
+	}
+
+	// The heuristics still apply without a representation
+	rb := NewForTest(ContainerNone, values...)
+	bitmapsEqual(t, FromArray(values), rb)
+	assert.Empty(t, NewForTest(ContainerRun).containers)
+}
+
+func TestBitmapWords(t *testing.T) {
+	rb := makeTestBitmap()
+
+	words, ok := rb.BitmapWords(1)
+	assert.True(t, ok)
+	assert.Len(t, words, 1024)
+
+	var values []uint32
+	for i, w := range words {
+		for bit := 0; bit < 64; bit++ {
+			if w&(1<<bit) != 0 {
+				values = append(values, 1<<16|uint32(i*64+bit))
+			}
+		}
+	}
+
+	var expect []uint32
+	rb.Range(func(x uint32) bool {
+		if x>>16 == 1 {
+			expect = append(expect, x)
+		}
+		return true
+	})
+	assert.Equal(t, expect, values)
+
+	// Array, run and missing containers are not exposed
+	for _, key := range []uint16{0, 2, 3, 65535} {
+		words, ok := rb.BitmapWords(key)
+		assert.False(t, ok)
+		assert.Nil(t, words)
+	}
+}
+
+func TestArrayify(t *testing.T) {
+	rb := makeTestBitmap()
+	rb.AddRange(3<<16, 4<<16) // full run container
+	clone := rb.Clone(nil)
+	values := rb.ToArray()
+	types := func() (out []ctype) {
+		for _, c := range rb.containers {
+			out = append(out, c.Type)
+		}
+		return
+	}
+
+	rb.Arrayify(65536, 3<<16)
+	assert.Equal(t, []ctype{typeArray, typeArray, typeArray, typeRun, typeArray}, types())
+	assert.Equal(t, values, rb.ToArray())
+	assert.NoError(t, rb.Validate())
+
+	rb.Arrayify(0, 4294967295)
+	assert.Equal(t, []ctype{typeArray, typeArray, typeArray, typeArray, typeArray}, types())
+	assert.Equal(t, values, rb.ToArray())
+	assert.NoError(t, rb.Validate())
+
+	// The clone keeps its own representation
+	assert.Equal(t, typeBitmap, clone.containers[1].Type)
+	assert.Equal(t, values, clone.ToArray())
 
+	rb.Arrayify(10, 10)
+	assert.Equal(t, values, rb.ToArray())
 }
@@ -0,0 +1,107 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+// SplitByCount partitions the bitmap by rank into bitmaps of at most chunkSize values
+// each, the first one holding the smallest values. Only the last chunk may be smaller.
+// Containers which fit entirely within a chunk are shared rather than copied, and a
+// non-positive chunk size returns no chunks at all.
+func (rb *Bitmap) SplitByCount(chunkSize int) []*Bitmap {
+	if chunkSize <= 0 {
+		return nil
+	}
+
+	var out []*Bitmap
+	chunk, left := &Bitmap{opts: rb.opts}, chunkSize
+	push := func(key uint16, c container) {
+		chunk.containers = append(chunk.containers, c)
+		chunk.index = append(chunk.index, key)
+		if left -= int(c.Size); left == 0 {
+			out = append(out, chunk)
+			chunk, left = &Bitmap{opts: rb.opts}, chunkSize
+		}
+	}
+
+	lows := borrowArray()
+	for i := range rb.containers {
+		c, key := &rb.containers[i], rb.index[i]
+		if int(c.Size) <= left {
+			push(key, c.share())
+			continue
+		}
+
+		// The container straddles chunks, so copy its values by rank
+		lows = c.appendValues(lows[:0])
+		for values := lows; len(values) > 0; {
+			n := min(left, len(values))
+			part := container{
+				Type: typeArray,
+				Size: uint32(n),
+				Data: append(make([]uint16, 0, n), values[:n]...),
+			}
+
+			part.optimize(rb.opts)
+			values = values[n:]
+			push(key, part)
+		}
+	}
+	release(lows)
+
+	if len(chunk.containers) > 0 {
+		out = append(out, chunk)
+	}
+	return out
+}
+
+// SplitByKey partitions the bitmap into shards covering aligned ranges of keysPerShard
+// container keys each, so that the high 16 bits of every shard are contiguous. Only the
+// non-empty shards are returned in ascending order and their containers are shared
+// rather than copied. A non-positive number of keys per shard returns no shards at all.
+func (rb *Bitmap) SplitByKey(keysPerShard int) []*Bitmap {
+	if keysPerShard <= 0 {
+		return nil
+	}
+
+	var out []*Bitmap
+	for i := 0; i < len(rb.index); {
+		shard := int(rb.index[i]) / keysPerShard
+		j := i + 1
+		for j < len(rb.index) && int(rb.index[j])/keysPerShard == shard {
+			j++
+		}
+
+		bm := &Bitmap{
+			containers: make([]container, j-i),
+			index:      append(make([]uint16, 0, j-i), rb.index[i:j]...),
+			opts:       rb.opts,
+		}
+		for k := range bm.containers {
+			bm.containers[k] = rb.containers[i+k].share()
+		}
+
+		out = append(out, bm)
+		i = j
+	}
+	return out
+}
+
+// appendValues appends the values of the container to dst in ascending order
+func (c *container) appendValues(dst []uint16) []uint16 {
+	switch c.Type {
+	case typeArray:
+		dst = append(dst, c.Data...)
+	case typeBitmap:
+		c.bmpRange(func(x uint32) bool {
+			dst = append(dst, uint16(x))
+			return true
+		})
+	case typeRun:
+		for i := 0; i+1 < len(c.Data); i += 2 {
+			for v := uint32(c.Data[i]); v <= uint32(c.Data[i+1]); v++ {
+				dst = append(dst, uint16(v))
+			}
+		}
+	}
+	return dst
+}
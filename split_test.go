@@ -0,0 +1,94 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitByCount(t *testing.T) {
+	src := makeTestBitmap()
+	src.AddRange(1<<20, 1<<20+100000)
+	count := src.Count()
+
+	for _, size := range []int{1, 7, 1000, 4096, 65536, count - 1, count, count + 1} {
+		t.Run(fmt.Sprintf("%d", size), func(t *testing.T) {
+			chunks := src.SplitByCount(size)
+			assert.Equal(t, (count+size-1)/size, len(chunks))
+
+			var values []uint32
+			for i, chunk := range chunks {
+				assert.NoError(t, chunk.Validate())
+				if i < len(chunks)-1 {
+					assert.Equal(t, size, chunk.Count())
+
+					// Chunks are ordered by rank, so they never overlap
+					hi, _ := chunk.Max()
+					lo, _ := chunks[i+1].Min()
+					assert.Less(t, hi, lo)
+				} else {
+					assert.LessOrEqual(t, chunk.Count(), size)
+				}
+
+				values = append(values, chunk.ToArray()...)
+			}
+
+			assert.Equal(t, src.ToArray(), values)
+		})
+	}
+
+	t.Run("ownership", func(t *testing.T) {
+		rb := makeTestBitmap()
+		chunks := rb.SplitByCount(rb.Count())
+		chunks[0].Remove(1)
+		chunks[0].Set(2)
+		bitmapsEqual(t, makeTestBitmap(), rb)
+	})
+
+	assert.Nil(t, src.SplitByCount(0))
+	assert.Nil(t, New().SplitByCount(10))
+}
+
+func TestSplitByKey(t *testing.T) {
+	src := makeTestBitmap()
+	src.AddRange(1<<20, 1<<20+1000000)
+
+	for _, keys := range []int{1, 2, 3, 16, 1 << 16} {
+		t.Run(fmt.Sprintf("%d", keys), func(t *testing.T) {
+			shards := src.SplitByKey(keys)
+			union := New()
+			for i, shard := range shards {
+				assert.NoError(t, shard.Validate())
+
+				// Every shard covers a single aligned key range, disjoint from the next
+				lo, _ := shard.Min()
+				hi, _ := shard.Max()
+				assert.Equal(t, int(lo>>16)/keys, int(hi>>16)/keys)
+				if i < len(shards)-1 {
+					next, _ := shards[i+1].Min()
+					assert.Less(t, int(hi>>16)/keys, int(next>>16)/keys)
+				}
+
+				union.Or(shard)
+			}
+
+			bitmapsEqual(t, src, union)
+		})
+	}
+
+	t.Run("ownership", func(t *testing.T) {
+		rb := makeTestBitmap()
+		shards := rb.SplitByKey(1)
+		assert.Equal(t, 4, len(shards))
+		shards[0].Remove(1)
+		shards[1].Set(0xFFFF + 1)
+		bitmapsEqual(t, makeTestBitmap(), rb)
+	})
+
+	assert.Nil(t, src.SplitByKey(0))
+	assert.Nil(t, New().SplitByKey(10))
+}
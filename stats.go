@@ -0,0 +1,134 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+// Stats represents a summary of the containers of a bitmap
+type Stats struct {
+	Containers int // Total number of containers
+	Arrays     int // Number of array containers
+	Bitmaps    int // Number of bitmap containers
+	Runs       int // Number of run containers
+	Count      int // Number of values in the bitmap
+	Bytes      int // Size of the container data in bytes
+}
+
+// Stats returns a summary of the containers of the bitmap, which is useful to inspect
+// the representation chosen for the data.
+func (rb *Bitmap) Stats() Stats {
+	stats := Stats{Containers: len(rb.containers)}
+	for i := range rb.containers {
+		c := &rb.containers[i]
+		switch c.Type {
+		case typeArray:
+			stats.Arrays++
+		case typeBitmap:
+			stats.Bitmaps++
+		case typeRun:
+			stats.Runs++
+		}
+
+		stats.Count += int(c.Size)
+		stats.Bytes += len(c.Data) * 2
+	}
+	return stats
+}
+
+// EstimateCount returns the number of values in the bitmap for query planning. The
+// bitmap does not cache its total count, so this sums the container sizes which is
+// exact and proportional to the number of containers, just like Count.
+func (rb *Bitmap) EstimateCount() int {
+	return rb.Count()
+}
+
+// EstimateCountSampled approximates the number of values in the bitmap by summing the
+// sizes of the given number of evenly spaced containers and extrapolating the result
+// to all of the containers. It is exact when there are not more containers than samples.
+func (rb *Bitmap) EstimateCountSampled(samples int) int {
+	n := len(rb.containers)
+	if samples <= 0 || samples >= n {
+		return rb.Count()
+	}
+
+	total := 0
+	for i := 0; i < samples; i++ {
+		total += int(rb.containers[i*n/samples].Size)
+	}
+	return total * n / samples
+}
+
+// EstimateRuns returns the number of runs the bitmap would have if every container were
+// converted to runs, counting the gaps between the values of each container without
+// converting it. Values adjacent across two containers still count as separate runs.
+func (rb *Bitmap) EstimateRuns() int {
+	runs := 0
+	for i := range rb.containers {
+		runs += rb.containers[i].runCount()
+	}
+	return runs
+}
+
+// FillRatios returns, for each populated container key, the fraction of the
+// 65536 possible values of that container which are set.
+func (rb *Bitmap) FillRatios() map[uint16]float64 {
+	out := make(map[uint16]float64, len(rb.containers))
+	for i := range rb.containers {
+		out[rb.index[i]] = float64(rb.containers[i].Size) / 65536
+	}
+	return out
+}
+
+// Cardinalities returns the keys of the populated containers in ascending order, along
+// with the number of values in each of them, which is useful to analyze data skew.
+func (rb *Bitmap) Cardinalities() (keys []uint16, counts []uint32) {
+	keys = append(make([]uint16, 0, len(rb.index)), rb.index...)
+	counts = make([]uint32, len(rb.containers))
+	for i := range rb.containers {
+		counts[i] = rb.containers[i].Size
+	}
+	return
+}
+
+// MergeStats summarizes how the containers of a bitmap were affected by a merge
+type MergeStats struct {
+	Created   int // Containers copied from the other bitmap as their key was missing
+	Grown     int // Existing containers which gained new values
+	Converted int // Existing containers which changed their representation
+	Shared    int // Containers still sharing their data with another bitmap (copy-on-write)
+}
+
+// MergeWithStats performs bitwise OR operation with other bitmap and returns a summary
+// of the resulting container changes, which is useful to measure compaction.
+func (rb *Bitmap) MergeWithStats(other *Bitmap) MergeStats {
+	var stats MergeStats
+	rb.or(other, &stats)
+	return stats
+}
+
+// merged records the change of a container which was merged in place
+func (s *MergeStats) merged(typ ctype, size uint32, c *container) {
+	if s == nil {
+		return
+	}
+
+	if c.Size > size {
+		s.Grown++
+	}
+	if c.Type != typ {
+		s.Converted++
+	}
+}
+
+// collect records the created containers and counts the shared ones after a merge
+func (s *MergeStats) collect(rb *Bitmap, created int) {
+	if s == nil {
+		return
+	}
+
+	s.Created += created
+	for i := range rb.containers {
+		if rb.containers[i].Shared {
+			s.Shared++
+		}
+	}
+}
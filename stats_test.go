@@ -0,0 +1,199 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFillRatios(t *testing.T) {
+	assert.Empty(t, New().FillRatios())
+
+	rb := New()
+	rb.AddRange(0, 65536)          // key 0: full
+	rb.AddRange(65536, 65536+4096) // key 1: 1/16th
+	rb.Set(3 << 16)                // key 3: single value
+	for i := 0; i < 32768; i++ {   // key 5: every other value
+		rb.Set(5<<16 | uint32(i*2))
+	}
+
+	ratios := rb.FillRatios()
+	assert.Equal(t, map[uint16]float64{
+		0: 1,
+		1: 0.0625,
+		3: 1.0 / 65536,
+		5: 0.5,
+	}, ratios)
+
+	_, ok := ratios[2]
+	assert.False(t, ok)
+}
+
+func TestCardinalities(t *testing.T) {
+	keys, counts := New().Cardinalities()
+	assert.Empty(t, keys)
+	assert.Empty(t, counts)
+
+	rb := makeTestBitmap()
+	rb.AddRange(7<<16, 7<<16+100)
+	keys, counts = rb.Cardinalities()
+	assert.Equal(t, []uint16{0, 1, 2, 7, 65535}, keys)
+	assert.Equal(t, []uint32{4, 8191, 1000, 100, 1}, counts)
+
+	total := 0
+	for _, n := range counts {
+		total += int(n)
+	}
+	assert.Equal(t, rb.Count(), total)
+
+	// The keys are a copy of the index
+	keys[0] = 42
+	assert.Equal(t, uint16(0), rb.index[0])
+}
+
+func TestMergeWithStats(t *testing.T) {
+	values := []uint32{1, 2, 3, 1<<16 | 10, 1<<16 | 20, 2<<16 | 1, 4 << 16}
+	rb, expect := FromArray(values), FromArray(values)
+
+	other := New()
+	for _, v := range []uint32{2, 3, 1<<16 | 30, 3<<16 | 5} {
+		other.Set(v)
+	}
+	other.ctrAdd(2, 2, newBmp(100, 200))
+
+	expect.Or(other)
+	stats := rb.MergeWithStats(other)
+	assert.Equal(t, MergeStats{
+		Created:   1, // key 3
+		Grown:     2, // keys 1 and 2
+		Converted: 1, // key 2 became a bitmap
+		Shared:    1, // key 3 is borrowed from other
+	}, stats)
+	bitmapsEqual(t, expect, rb)
+
+	t.Run("empty", func(t *testing.T) {
+		empty := New()
+		stats := empty.MergeWithStats(other)
+		assert.Equal(t, MergeStats{Created: 4, Shared: 4}, stats)
+		assert.Equal(t, MergeStats{}, empty.MergeWithStats(nil))
+	})
+}
+
+func TestStats(t *testing.T) {
+	assert.Equal(t, Stats{}, New().Stats())
+
+	stats := makeTestBitmap().Stats()
+	assert.Equal(t, Stats{
+		Containers: 4,
+		Arrays:     2,
+		Bitmaps:    1,
+		Runs:       1,
+		Count:      makeTestBitmap().Count(),
+		Bytes:      2 * (4 + 4096 + 2 + 1),
+	}, stats)
+}
+
+func TestRepresentationAfterOptimize(t *testing.T) {
+	tests := []struct {
+		name   string
+		values func() []uint32
+		expect func(Stats) int
+	}{
+		{"seq", func() []uint32 {
+			data, _ := genSeq(200000, 0)()
+			return data
+		}, func(s Stats) int { return s.Runs }},
+		{"sparse", func() []uint32 {
+			data, _ := genSparse(10000)()
+			return data
+		}, func(s Stats) int { return s.Arrays }},
+		{"dense", func() []uint32 {
+			data, _ := genRand(200000, 4<<16)()
+			return data
+		}, func(s Stats) int { return s.Bitmaps }},
+		{"runs", func() (data []uint32) {
+			for v := uint32(0); v < 4<<16; v += 200 {
+				for i := uint32(0); i < 100; i++ {
+					data = append(data, v+i)
+				}
+			}
+			return
+		}, func(s Stats) int { return s.Runs }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rb := New()
+			for _, v := range tt.values() {
+				rb.Set(v)
+			}
+
+			rb.Optimize()
+			stats := rb.Stats()
+			assert.Greater(t, tt.expect(stats)*2, stats.Containers, "%+v", stats)
+		})
+	}
+}
+
+func TestEstimateCount(t *testing.T) {
+	assert.Equal(t, 0, New().EstimateCount())
+	assert.Equal(t, 0, New().EstimateCountSampled(10))
+
+	rb := makeTestBitmap()
+	assert.Equal(t, rb.Count(), rb.EstimateCount())
+	assert.Equal(t, rb.Count(), rb.EstimateCountSampled(0))
+	assert.Equal(t, rb.Count(), rb.EstimateCountSampled(100))
+
+	// Uniformly distributed values across many containers
+	data, _ := genRand(1e6, 1e9)()
+	rb, _ = testPair(data)
+	count := float64(rb.Count())
+	for _, samples := range []int{100, 1000, 10000} {
+		estimate := float64(rb.EstimateCountSampled(samples))
+		assert.InEpsilon(t, count, estimate, 0.05, "samples %d", samples)
+	}
+}
+
+func TestEstimateRuns(t *testing.T) {
+	assert.Equal(t, 0, New().EstimateRuns())
+	assert.Equal(t, 4+8191+1+1, makeTestBitmap().EstimateRuns())
+
+	// Every container is forced into runs, regardless of whether it is worth it
+	for _, gen := range []dataGen{genSeq(200000, 0), genRand(200000, 4<<16), genSparse(1000), genMixed()} {
+		data, name := gen()
+		rb, _ := testPair(data)
+		runs := 0
+		for _, c := range rb.CloneTrimmed().containers {
+			if c.Type == typeBitmap {
+				c.bmpToArr()
+			}
+			if c.Type == typeArray {
+				arrToRun(&c)
+			}
+			runs += len(c.Data) / 2
+		}
+
+		assert.Equal(t, runs, rb.EstimateRuns(), name)
+	}
+
+	// Optimize converts long runs, leaving the estimate unchanged
+	rb := New()
+	for v := uint32(0); v < 4<<16; v += 256 {
+		rb.AddRange(v, v+100)
+		rb.Set(v + 150)
+	}
+
+	estimate := rb.EstimateRuns()
+	rb.Optimize()
+	assert.Equal(t, rb.Stats().Containers, rb.Stats().Runs)
+	assert.Equal(t, estimate, rb.EstimateRuns())
+
+	runs := 0
+	for _, c := range rb.containers {
+		runs += len(c.Data) / 2
+	}
+	assert.Equal(t, estimate, runs)
+}
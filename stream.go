@@ -0,0 +1,131 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// typeEnd is the container type of the terminator ending a stream of containers
+const typeEnd ctype = 0xFF
+
+// StreamWriter writes a bitmap in the stream format, where containers are written as
+// soon as they are complete and a terminator replaces the leading container count.
+// This allows forward-only writers to encode a bitmap without knowing its size.
+type StreamWriter struct {
+	dst    io.Writer
+	tail   Bitmap // Holds the container currently being built
+	header [7]byte
+	n      int64
+	err    error
+}
+
+// NewStreamWriter creates a new writer of the stream format
+func NewStreamWriter(w io.Writer) *StreamWriter {
+	return &StreamWriter{dst: w}
+}
+
+// Add appends the value x to the stream, assuming it is greater than or equal to every
+// value added before, otherwise ErrUnsorted is returned. Once the values move on to
+// the next container, the previous one is written out.
+func (s *StreamWriter) Add(x uint32) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	if n := len(s.tail.index); n > 0 && s.tail.index[n-1] != uint16(x>>16) {
+		if s.tail.index[n-1] > uint16(x>>16) {
+			return fmt.Errorf("%w: %d is below the container %d", ErrUnsorted, x, s.tail.index[n-1])
+		}
+
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+
+	return s.tail.AppendSorted(x)
+}
+
+// Written returns the number of bytes written so far
+func (s *StreamWriter) Written() int64 {
+	return s.n
+}
+
+// Close writes out the last container followed by the terminator. It does not close
+// the underlying writer.
+func (s *StreamWriter) Close() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	// The terminator is a header with no key, no size and the end type
+	clear(s.header[:])
+	s.header[2] = byte(typeEnd)
+	if _, s.err = s.dst.Write(s.header[:]); s.err != nil {
+		return s.err
+	}
+
+	s.n += int64(len(s.header))
+	s.err = io.ErrClosedPipe // Nothing can be added once closed
+	return nil
+}
+
+// flush writes out the container currently being built, if any
+func (s *StreamWriter) flush() error {
+	if s.err != nil || len(s.tail.containers) == 0 {
+		return s.err
+	}
+
+	c := &s.tail.containers[0]
+	c.optimize(s.tail.opts)
+
+	var m int64
+	m, s.err = writeContainer(s.dst, &s.header, s.tail.index[0], c)
+	s.n += m
+	s.tail.Clear()
+	return s.err
+}
+
+// WriteStream writes the bitmap to a writer in the stream format, where the containers
+// are followed by a terminator instead of being preceded by their count.
+func (rb *Bitmap) WriteStream(w io.Writer) (int64, error) {
+	s := NewStreamWriter(w)
+	for i := range rb.containers {
+		m, err := writeContainer(w, &s.header, rb.index[i], &rb.containers[i])
+		if s.n += m; err != nil {
+			return s.n, err
+		}
+	}
+
+	err := s.Close()
+	return s.n, err
+}
+
+// ReadStream reads the bitmap from a reader in the stream format, stopping right after
+// the terminator so that the reader can be positioned on whatever follows it.
+func (rb *Bitmap) ReadStream(r io.Reader) (int64, error) {
+	rb.Clear()
+	var n int64
+	for {
+		key, typ, m, err := readHeader(r)
+		if n += m; err != nil {
+			return n, err
+		}
+
+		if typ == typeEnd {
+			var size uint32
+			if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+				return n, err
+			}
+			return n + 4, nil
+		}
+
+		if m, err = rb.readContainer(r, key, typ); err != nil {
+			return n + m, err
+		}
+		n += m
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamWriter(t *testing.T) {
+	for _, gen := range []dataGen{genSeq(100000, 5), genSparse(1000), genBoundary(), genMixed()} {
+		data, name := gen()
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewStreamWriter(&buf)
+			for _, v := range data {
+				assert.NoError(t, w.Add(v))
+			}
+			assert.NoError(t, w.Close())
+			assert.Equal(t, int64(buf.Len()), w.Written())
+
+			// Trailing data must be left untouched for the caller
+			buf.WriteString("tail")
+
+			rb := New()
+			n, err := rb.ReadStream(iotest.OneByteReader(&buf))
+			assert.NoError(t, err)
+			assert.Equal(t, w.Written(), n)
+			assert.NoError(t, rb.Validate())
+			bitmapsEqual(t, FromArray(data), rb)
+
+			rest, _ := io.ReadAll(&buf)
+			assert.Equal(t, "tail", string(rest))
+		})
+	}
+}
+
+func TestStreamWriter_Errors(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewStreamWriter(&buf)
+	assert.NoError(t, w.Add(1<<16+5))
+	assert.NoError(t, w.Add(1<<16+5))
+	assert.ErrorIs(t, w.Add(1<<16), ErrUnsorted)
+	assert.NoError(t, w.Add(2<<16))
+	assert.ErrorIs(t, w.Add(5), ErrUnsorted)
+	assert.NoError(t, w.Close())
+	assert.Error(t, w.Add(3<<16))
+
+	// A truncated stream is missing its terminator
+	data := buf.Bytes()
+	_, err := New().ReadStream(bytes.NewReader(data[:len(data)-7]))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// An empty stream only holds the terminator
+	buf.Reset()
+	assert.NoError(t, NewStreamWriter(&buf).Close())
+	assert.Equal(t, 7, buf.Len())
+}
+
+func TestWriteStream(t *testing.T) {
+	for _, rb := range []*Bitmap{New(), makeTestBitmap()} {
+		var buf bytes.Buffer
+		n, err := rb.WriteStream(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(buf.Len()), n)
+
+		// The stream format differs from the regular one only by its framing
+		assert.Equal(t, len(rb.ToBytes())+3, buf.Len())
+
+		out := New()
+		m, err := out.ReadStream(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, n, m)
+		bitmapsEqual(t, rb, out)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidContainer is returned when a bitmap contains a malformed container
+var ErrInvalidContainer = errors.New("roaring: invalid container")
+
+// Validate checks the internal consistency of the bitmap and returns an error
+// describing the first violation found, if any.
+func (rb *Bitmap) Validate() error {
+	if len(rb.index) != len(rb.containers) {
+		return fmt.Errorf("%w: %d keys for %d containers", ErrInvalidContainer, len(rb.index), len(rb.containers))
+	}
+
+	for i := range rb.containers {
+		key := rb.index[i]
+		if i > 0 && rb.index[i-1] >= key {
+			return fmt.Errorf("%w: key %d is out of order", ErrInvalidContainer, key)
+		}
+
+		if err := rb.containers[i].validate(); err != nil {
+			return fmt.Errorf("%w: key %d, %v", ErrInvalidContainer, key, err)
+		}
+	}
+	return nil
+}
+
+// RecomputeSizes recomputes the cardinality of every container from its data, repairing
+// any drift of the cached sizes. Containers which turn out to be empty are removed.
+func (rb *Bitmap) RecomputeSizes() {
+	n := 0
+	for i := range rb.containers {
+		c := &rb.containers[i]
+		if c.Size = c.cardinality(); c.Size == 0 {
+			continue
+		}
+
+		rb.containers[n] = *c
+		rb.index[n] = rb.index[i]
+		n++
+	}
+
+	rb.containers = rb.containers[:n]
+	rb.index = rb.index[:n]
+}
+
+// validate checks the internal consistency of the container
+func (c *container) validate() error {
+	switch c.Type {
+	case typeArray:
+		for i := 1; i < len(c.Data); i++ {
+			if c.Data[i-1] >= c.Data[i] {
+				return fmt.Errorf("array value %d is out of order", c.Data[i])
+			}
+		}
+	case typeBitmap:
+		if len(c.Data) != bitmapSize {
+			return fmt.Errorf("bitmap has %d words, expected %d", len(c.Data), bitmapSize)
+		}
+	case typeRun:
+		if len(c.Data)%2 != 0 {
+			return fmt.Errorf("run has an odd length of %d", len(c.Data))
+		}
+
+		for i := 0; i < len(c.Data); i += 2 {
+			switch {
+			case c.Data[i] > c.Data[i+1]:
+				return fmt.Errorf("run [%d, %d] is inverted", c.Data[i], c.Data[i+1])
+			case i > 0 && c.Data[i] <= c.Data[i-1]:
+				return fmt.Errorf("run [%d, %d] overlaps the previous run", c.Data[i], c.Data[i+1])
+			}
+		}
+	default:
+		return fmt.Errorf("unknown type %d", c.Type)
+	}
+
+	switch size := c.cardinality(); {
+	case size == 0:
+		return errors.New("container is empty")
+	case size != c.Size:
+		return fmt.Errorf("size is %d, expected %d", c.Size, size)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Roman Atachiants and contributors. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root
+
+package roaring
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		assert.NoError(t, New().Validate())
+		assert.NoError(t, makeTestBitmap().Validate())
+		for _, typ := range []ctype{typeArray, typeBitmap, typeRun} {
+			rb, _ := changeType(typ)
+			assert.NoError(t, rb.Validate())
+		}
+	})
+
+	for _, tc := range []struct {
+		name string
+		cnr  *container
+	}{
+		{"arr unsorted", &container{Type: typeArray, Size: 3, Data: []uint16{1, 3, 2}}},
+		{"arr duplicate", &container{Type: typeArray, Size: 3, Data: []uint16{1, 2, 2}}},
+		{"arr size", &container{Type: typeArray, Size: 5, Data: []uint16{1, 2, 3}}},
+		{"arr empty", &container{Type: typeArray, Size: 0, Data: []uint16{}}},
+		{"bmp short", &container{Type: typeBitmap, Size: 1, Data: append(make([]uint16, 4094), 1)}},
+		{"bmp size", &container{Type: typeBitmap, Size: 2, Data: newBmp(1).Data}},
+		{"run odd", &container{Type: typeRun, Size: 3, Data: []uint16{1, 3, 5}}},
+		{"run inverted", &container{Type: typeRun, Size: 3, Data: []uint16{3, 1}}},
+		{"run overlap", &container{Type: typeRun, Size: 6, Data: []uint16{1, 3, 3, 5}}},
+		{"run size", &container{Type: typeRun, Size: 2, Data: []uint16{1, 3}}},
+		{"unknown type", &container{Type: 0xFF, Size: 1, Data: []uint16{1}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rb := New()
+			rb.ctrAdd(0, 0, tc.cnr)
+			assert.ErrorIs(t, rb.Validate(), ErrInvalidContainer)
+		})
+	}
+
+	t.Run("keys out of order", func(t *testing.T) {
+		rb := FromArray([]uint32{1 << 16, 2 << 16})
+		rb.index[0], rb.index[1] = rb.index[1], rb.index[0]
+		assert.ErrorIs(t, rb.Validate(), ErrInvalidContainer)
+	})
+}
+
+func TestBitmapShortData(t *testing.T) {
+	rb := New()
+	rb.ctrAdd(0, 0, &container{
+		Type: typeBitmap,
+		Size: 1,
+		Data: append(make([]uint16, 4094), 1), // 4095 words, value 65504
+	})
+
+	assert.ErrorIs(t, rb.Validate(), ErrInvalidContainer)
+	assert.False(t, rb.Contains(65535))
+	assert.True(t, rb.Contains(65504))
+
+	// Read paths and operands see a padded view without modifying the container
+	full := bitmapOf(newBmp(seq(0, 65536, 2)...))
+	for _, fn := range []func(a, b *Bitmap){
+		func(a, b *Bitmap) { a.And(b) },
+		func(a, b *Bitmap) { a.AndNot(b) },
+		func(a, b *Bitmap) { a.Or(b) },
+		func(a, b *Bitmap) { a.Xor(b) },
+	} {
+		other := full.Clone(nil)
+		fn(other, rb)
+		assert.NoError(t, other.Validate())
+	}
+
+	assert.Equal(t, 1, rb.AndCardinality(full))
+	assert.Len(t, rb.containers[0].Data, 4095)
+
+	// Writes pad the payload to its full size first
+	rb.Set(65535)
+	assert.Len(t, rb.containers[0].Data, bitmapSize)
+	assert.Equal(t, []uint32{65504, 65535}, rb.ToArray())
+	assert.NoError(t, rb.Validate())
+}
+
+func TestRecomputeSizes(t *testing.T) {
+	rb := makeTestBitmap()
+	count := rb.Count()
+	for i := range rb.containers {
+		rb.containers[i].Size += 7
+	}
+
+	assert.Error(t, rb.Validate())
+	assert.Equal(t, count+7*len(rb.containers), rb.Count())
+
+	rb.RecomputeSizes()
+	assert.NoError(t, rb.Validate())
+	assert.Equal(t, count, rb.Count())
+	bitmapsEqual(t, makeTestBitmap(), rb)
+
+	t.Run("empty", func(t *testing.T) {
+		rb := makeTestBitmap()
+		rb.containers[1].Data = make([]uint16, bitmapSize)
+		rb.RecomputeSizes()
+
+		assert.NoError(t, rb.Validate())
+		assert.Equal(t, 3, len(rb.containers))
+		assert.Equal(t, []uint16{0, 2, 65535}, rb.index)
+	})
+}